@@ -0,0 +1,480 @@
+// Package combiner merges Tailscale policy files from a directory of "child"
+// files into a single "parent" policy file.
+package combiner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+	"github.com/tailscale/hujson"
+)
+
+// Combiner merges the child files found in a directory into a parent file.
+// Create one with New.
+type Combiner struct {
+	parentPath     string
+	childDirs      []string
+	childFileList  []string
+	include        []string
+	exclude        []string
+	configPath     string
+	allow          []string
+	sections       Registry
+	duplicates     DuplicatePolicies
+	provenance     ProvenanceStyle
+	order          MergeOrder
+	parentFileName string
+	logger         *log.Logger
+
+	warnings Diagnostics
+}
+
+// Option configures a Combiner.
+type Option func(*Combiner)
+
+// WithParent sets the parent file children are merged into. Without it,
+// children are merged into an empty policy.
+func WithParent(path string) Option {
+	return func(c *Combiner) { c.parentPath = path }
+}
+
+// WithChildDir adds a directory searched for .json, .hujson, .yaml, .yml and
+// .toml child files. Directories are searched in the order they are added.
+func WithChildDir(path string) Option {
+	return func(c *Combiner) { c.childDirs = append(c.childDirs, path) }
+}
+
+// WithChildFiles adds child files to merge after those found in the child
+// directories. They are sorted by path like the files in a directory, or
+// by the order set with WithOrder, not kept in the order given. Files
+// without a .json, .hujson, .yaml, .yml or .toml extension are skipped.
+func WithChildFiles(paths ...string) Option {
+	return func(c *Combiner) { c.childFileList = append(c.childFileList, paths...) }
+}
+
+// WithInclude restricts the child files merged to those matching one of
+// globs. Files in child directories are matched relative to the directory,
+// and files from WithChildFiles as given.
+func WithInclude(globs ...string) Option {
+	return func(c *Combiner) { c.include = append(c.include, globs...) }
+}
+
+// WithExclude skips the child files matching any of globs, matched like the
+// globs from WithInclude.
+func WithExclude(globs ...string) Option {
+	return func(c *Combiner) { c.exclude = append(c.exclude, globs...) }
+}
+
+// WithConfig sets the config file with per-directory settings for every
+// child. Without it, files in a child directory use DefaultConfigFile at its
+// root if it exists, and files from WithChildFiles use DefaultConfigFile in
+// the closest directory above them that has one.
+func WithConfig(path string) Option {
+	return func(c *Combiner) { c.configPath = path }
+}
+
+// WithAllow sets the sections allowed from children that are not matched by
+// the config file.
+func WithAllow(sections ...string) Option {
+	return func(c *Combiner) { c.allow = sections }
+}
+
+// WithRegistry replaces the handlers for the sections that can be allowed
+// from children, which default to NewRegistry.
+func WithRegistry(sections Registry) Option {
+	return func(c *Combiner) { c.sections = sections }
+}
+
+// WithDuplicates sets the policy for keys defined more than once in an
+// object section, which defaults to DefaultDuplicatePolicies.
+func WithDuplicates(policies DuplicatePolicies) Option {
+	return func(c *Combiner) { c.duplicates = policies }
+}
+
+// WithProvenance sets the style of the comments recording the file each
+// entry came from, which defaults to ProvenanceFirst.
+func WithProvenance(style ProvenanceStyle) Option {
+	return func(c *Combiner) { c.provenance = style }
+}
+
+// WithParentFileName sets the name of the parent files in the child
+// directory and its subdirectories, which defaults to DefaultParentFile.
+func WithParentFileName(name string) Option {
+	return func(c *Combiner) { c.parentFileName = name }
+}
+
+// WithOrder sets the order child files are merged in, which defaults to
+// OrderLexical.
+func WithOrder(order MergeOrder) Option {
+	return func(c *Combiner) { c.order = order }
+}
+
+// WithLogger sets the logger for progress messages, which are discarded by
+// default.
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Combiner) { c.logger = logger }
+}
+
+// New returns a Combiner configured by opts.
+func New(opts ...Option) *Combiner {
+	c := &Combiner{
+		sections:       NewRegistry(),
+		duplicates:     DefaultDuplicatePolicies(),
+		provenance:     ProvenanceFirst,
+		order:          OrderLexical,
+		parentFileName: DefaultParentFile,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Result is the outcome of a successful Combine.
+type Result struct {
+	// Policy is the combined policy, with comments recording the file each
+	// entry came from in the style set by WithProvenance.
+	Policy *jwcc.Object
+	// Warnings are problems that did not stop the merge.
+	Warnings Diagnostics
+
+	sources map[jwcc.Value]Source
+}
+
+// ParsedDocument is a policy file read by Parse, and the settings that apply
+// to it when it is merged as a child.
+type ParsedDocument struct {
+	Path   string
+	Object *jwcc.Object
+
+	// Sections, when set, overrides the sections allowed from this document
+	// when it is merged as a child.
+	Sections map[string]SectionHandler
+	// Namespace, when set, restricts the tags, groups and other selectors
+	// this document may grant access to or define when merged as a child.
+	Namespace []string
+
+	// delegation is set when this document is a parent file in the child
+	// directory, and delegator is the nearest parent file above this
+	// document.
+	delegation *delegation
+	delegator  *ParsedDocument
+	// configPath is the config file that applies to this document as a
+	// child, if any.
+	configPath string
+}
+
+// Combine merges the children into the parent. Problems in every file are
+// collected and returned together as Diagnostics, including any warnings,
+// rather than stopping at the first one.
+func (c *Combiner) Combine(ctx context.Context) (*Result, error) {
+	c.warnings = nil
+	if !slices.Contains(ProvenanceStyles, c.provenance) {
+		return nil, Diagnostic{Severity: SeverityError, Rule: RuleInvalidArgument, Message: fmt.Sprintf("unsupported provenance style [%s], expected one of %v", c.provenance, ProvenanceStyles)}
+	}
+	if !slices.Contains(MergeOrders, c.order) {
+		return nil, Diagnostic{Severity: SeverityError, Rule: RuleInvalidArgument, Message: fmt.Sprintf("unsupported merge order [%s], expected one of %v", c.order, MergeOrders)}
+	}
+
+	var diags Diagnostics
+	parentDoc, err := c.parseParent()
+	if err != nil {
+		// The children are still checked against an empty parent, so their
+		// problems are reported along with the parent's.
+		diags = append(diags, CollectDiagnostics(err)...)
+		parentDoc = &ParsedDocument{Path: c.parentPath}
+	}
+	if parentDoc.Object == nil {
+		parentDoc.Object = &jwcc.Object{
+			Members: make([]*jwcc.Member, 0),
+		}
+	}
+
+	childDocs, err := c.gatherChildren(ctx)
+	if err != nil {
+		diags = append(diags, CollectDiagnostics(err)...)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	childDocs, err = rejectGenerated(childDocs)
+	if err != nil {
+		diags = append(diags, CollectDiagnostics(err)...)
+	}
+
+	sections := maps.Clone(c.sections)
+	configs, err := c.loadConfigs(withDelegators(childDocs), sections)
+	for _, d := range CollectDiagnostics(err) {
+		// A config that failed to load when ordering the children has
+		// already been reported.
+		if !slices.Contains(diags, d) {
+			diags = append(diags, d)
+		}
+	}
+	if err != nil {
+		// The sections allowed from children under a config that failed to
+		// load are unknown, so they are not merged.
+		childDocs = slices.DeleteFunc(childDocs, func(child *ParsedDocument) bool {
+			return child.configPath != "" && configs[child.configPath] == nil
+		})
+	}
+
+	aclSections, err := getAllowedSections(c.allow, sections)
+	if err != nil {
+		diags = append(diags, CollectDiagnostics(err)...)
+	}
+	c.logf("allowing ACL sections %v\n", c.allow)
+
+	for _, child := range withDelegators(childDocs) {
+		config := configs[child.configPath]
+		if config == nil {
+			continue
+		}
+		if _, ok := config.rel(child.Path); !ok {
+			diags = append(diags, Diagnostic{Path: child.Path, Severity: SeverityError, Rule: RuleInvalidConfig, Message: fmt.Sprintf("[%s] is outside the directory of config file [%s]", child.Path, config.Path)})
+			continue
+		}
+		if namespace, ok := config.namespace(child.Path); ok {
+			c.logf("[%s] has namespace %v\n", child.Path, namespace)
+			child.Namespace = namespace
+		}
+
+		allowed, ok := config.allowedSections(child.Path)
+		if !ok {
+			continue
+		}
+		c.logf("allowing ACL sections %v from [%s]\n", allowed, child.Path)
+		child.Sections, err = getAllowedSections(allowed, sections)
+		if err != nil {
+			diags = append(diags, CollectDiagnostics(err)...)
+		}
+	}
+
+	err = delegate(childDocs, aclSections, sections)
+	if err != nil {
+		diags = append(diags, CollectDiagnostics(err)...)
+	}
+
+	diags = append(diags, canonicalizeSections(parentDoc, sections)...)
+	for _, child := range childDocs {
+		diags = append(diags, canonicalizeSections(child, sections)...)
+	}
+
+	err = c.mergeDocs(aclSections, parentDoc, childDocs)
+	if err != nil {
+		diags = append(diags, CollectDiagnostics(err)...)
+	}
+
+	if len(diags) > 0 {
+		return nil, append(c.warnings, diags...)
+	}
+	sources := recordSources(parentDoc)
+	applyProvenance(parentDoc.Object, c.provenance)
+	markGenerated(parentDoc.Object, parentDoc.Path, c.order)
+	return &Result{Policy: parentDoc.Object, Warnings: c.warnings, sources: sources}, nil
+}
+
+// parseParent parses the parent file, if any, removing the entries merged
+// from children when it was previously combined by this tool.
+func (c *Combiner) parseParent() (*ParsedDocument, error) {
+	if c.parentPath == "" {
+		return &ParsedDocument{}, nil
+	}
+	c.logf("parsing [%v]...\n", c.parentPath)
+	parentDoc, err := Parse(c.parentPath)
+	if err != nil {
+		return nil, err
+	}
+	err = c.stripGenerated(parentDoc)
+	if err != nil {
+		return nil, err
+	}
+	return parentDoc, nil
+}
+
+// warn records a problem that does not stop the merge.
+func (c *Combiner) warn(d Diagnostic) {
+	c.warnings = append(c.warnings, d)
+}
+
+func (c *Combiner) logf(format string, a ...any) {
+	if c.logger != nil {
+		c.logger.Printf(format, a...)
+	}
+}
+
+// allowedSections returns the sections allowed from d as a child, which are
+// d.Sections if set or defaults otherwise.
+func (d *ParsedDocument) allowedSections(defaults map[string]SectionHandler) map[string]SectionHandler {
+	if d.Sections != nil {
+		return d.Sections
+	}
+	return defaults
+}
+
+func (c *Combiner) mergeDocs(sections map[string]SectionHandler, parentDoc *ParsedDocument, childDocs []*ParsedDocument) error {
+	var diags Diagnostics
+
+	err := checkNamespaces(childDocs)
+	diags = append(diags, CollectDiagnostics(err)...)
+
+	err = c.resolveDuplicates(sections, parentDoc, childDocs)
+	diags = append(diags, CollectDiagnostics(err)...)
+
+	addParentPathComments(parentDoc)
+
+	for _, child := range childDocs {
+		if child.Path == parentDoc.Path {
+			c.logf("skipping [%s], same doc as parent\n", child.Path)
+			continue
+		}
+
+		// Sections are merged in sorted order, so the output and the
+		// diagnostics are the same on every run.
+		allowed := child.allowedSections(sections)
+		for _, sectionKey := range slices.Sorted(maps.Keys(allowed)) {
+			handlerFn := allowed[sectionKey]
+			childSection := child.Object.Find(sectionKey)
+			if childSection == nil {
+				continue
+			}
+
+			c.logf("merging [%s] from [%s]\n", sectionKey, child.Path)
+			err := handlerFn(sectionKey, parentDoc.Path, parentDoc.Object, child.Path, childSection)
+			diags = append(diags, CollectDiagnostics(err)...)
+			child.Object.Members = removeMember(child.Object, childSection.Key.String())
+		}
+
+		for _, remainingSection := range child.Object.Members {
+			if _, ok := sections[remainingSection.Key.String()]; ok && child.delegator != nil {
+				diags = append(diags, errorAt(child.Path, remainingSection, RuleUnsupportedSection, "unsupported section [\"%s\"], not allowed by [%s]", remainingSection.Key, child.delegator.Path))
+				continue
+			}
+			diags = append(diags, errorAt(child.Path, remainingSection, RuleUnsupportedSection, "unsupported section [\"%s\"]", remainingSection.Key))
+		}
+	}
+
+	parentDoc.Object.Sort()
+
+	return diags.err()
+}
+
+// gatherChildren parses every child file returned by Files. Files that fail
+// to parse or are found more than once are skipped and returned together as
+// Diagnostics along with the children that parsed successfully.
+func (c *Combiner) gatherChildren(ctx context.Context) ([]*ParsedDocument, error) {
+	files, err := c.Files(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	children := []*ParsedDocument{}
+	var diags Diagnostics
+	listed := []*ParsedDocument{}
+	paths := map[*ParsedDocument]string{}
+	parentFiles := map[string]*ParsedDocument{}
+	for _, f := range files {
+		if f.duplicate {
+			diags = append(diags, Diagnostic{Path: f.Path, Severity: SeverityError, Rule: RuleDuplicateFile, Message: fmt.Sprintf("[%s] is %s", f.Path, f.Reason)})
+			continue
+		}
+		if !f.Merge {
+			c.logf("skipping [%s], %s\n", f.Path, f.Reason)
+			continue
+		}
+
+		c.logf("parsing [%v]...\n", f.Path)
+		var doc *ParsedDocument
+		if filepath.Base(f.Path) == c.parentFileName {
+			doc, err = c.parseParentFile(f.Path)
+		} else {
+			doc, err = Parse(f.Path)
+		}
+		if err != nil {
+			diags = append(diags, CollectDiagnostics(err)...)
+			continue
+		}
+		path := absPath(f.Path)
+		if doc.delegation != nil {
+			parentFiles[filepath.Dir(path)] = doc
+		}
+		doc.configPath = c.childConfigPath(f)
+		paths[doc] = path
+		children = append(children, doc)
+		if f.sourceIndex == len(c.childDirs) {
+			listed = append(listed, doc)
+		}
+	}
+
+	// Listed files are narrowed by the parent files above them, as if they
+	// were found in a child directory.
+	docs := slices.Clone(children)
+	for _, child := range listed {
+		found, err := c.findParentFiles(parentFiles, child.Path, child.configPath)
+		diags = append(diags, CollectDiagnostics(err)...)
+		for _, doc := range found {
+			paths[doc] = absPath(doc.Path)
+		}
+		docs = append(docs, found...)
+	}
+
+	for _, doc := range docs {
+		doc.delegator = nearestParentFile(parentFiles, doc, paths[doc])
+	}
+	return children, diags.err()
+}
+
+// Parse reads the policy file at path, which must contain a HuJSON object,
+// or a YAML or TOML document with a .yaml, .yml or .toml extension that is
+// converted to HuJSON keeping the location of each value.
+func Parse(path string) (*ParsedDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, Diagnostic{Path: path, Severity: SeverityError, Rule: RuleRead, Message: err.Error()}
+	}
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		data, err = convertYAML(path, data)
+	case ".toml":
+		data, err = convertTOML(path, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := jwcc.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, parseError(path, err)
+	}
+
+	root, ok := doc.Value.(*jwcc.Object)
+	if !ok {
+		return nil, errorAt(path, doc.Value, RuleInvalidFormat, "invalid file format: document root is [%T], expected [object]", doc.Value)
+	}
+
+	return &ParsedDocument{Path: path, Object: root}, nil
+}
+
+// Format returns doc as standardized HuJSON.
+func Format(doc *jwcc.Object) ([]byte, error) {
+	var sb strings.Builder
+	err := jwcc.Format(&sb, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	formatted, err := hujson.Format([]byte(sb.String()))
+	if err != nil {
+		return nil, fmt.Errorf("error formatting: %w", err)
+	}
+	return formatted, nil
+}
@@ -0,0 +1,1151 @@
+package combiner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+const (
+	ACL_PARENT = `{
+	"groups": {
+		"group:engineering": [
+			"dave@example.com",
+			"laura@example.com",
+		],
+		"group:sales": [
+			"brad@example.com",
+			"alice@example.com",
+		],
+	},
+	"acls": [
+		{
+			"action": "accept",
+			"src": ["group:security-team@example.com"],
+			"dst": ["tag:logging:*"]
+		}
+	],
+	"tagOwners": {
+		"tag:logging": ["group:security-team@example.com"]
+	},
+	"autoApprovers": {
+		"routes": {
+			"192.0.2.0/24": ["group:engineering", "alice@example.com", "tag:foo"],
+		},
+		"exitNode": ["tag:bar"],
+	},
+}`
+)
+
+func TestMergeDocsEmptyParent(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		// empty parent
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"foo":"bar"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	sections := map[string]SectionHandler{
+		"goodpath": ObjectHandler(),
+	}
+
+	err = New().mergeDocs(sections, parentDoc, []*ParsedDocument{childDoc})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(parentDoc.Object.Members) != 1 {
+		t.Fatalf("parent members length should be [1], got [%v]", len(parentDoc.Object.Members))
+	}
+
+	if parentDoc.Object.IndexKey(ast.TextEqual("goodpath")) != 0 {
+		t.Fatalf("section index key length should be [0], got [%v]", parentDoc.Object.IndexKey(ast.TextEqual("goodpath")))
+	}
+}
+
+func TestMergeDocsParentWithDifferentMembers(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"foo":"bar"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	sections := map[string]SectionHandler{
+		"goodpath": ObjectHandler(),
+	}
+
+	err = New().mergeDocs(sections, parentDoc, []*ParsedDocument{childDoc})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(parentDoc.Object.Members) != 5 {
+		t.Fatalf("parent members length should be [5], got [%v]", len(parentDoc.Object.Members))
+	}
+}
+
+func TestMergeDocsParentWithSameMember(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"bar":"foo"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"foo":"bar"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	sections := map[string]SectionHandler{
+		"goodpath": ObjectHandler(),
+	}
+
+	err = New().mergeDocs(sections, parentDoc, []*ParsedDocument{childDoc})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(parentDoc.Object.Members) != 1 {
+		t.Fatalf("parent members length should be [1], got [%v]", len(parentDoc.Object.Members))
+	}
+
+	memberIndexKey := parentDoc.Object.IndexKey(ast.TextEqual("goodpath"))
+	if memberIndexKey != 0 {
+		t.Fatalf("section index key length should be [0], got [%v]", memberIndexKey)
+	}
+
+	member := parentDoc.Object.Members[memberIndexKey]
+	memberObjectMembers := member.Value.(*jwcc.Object).Members
+	if len(memberObjectMembers) != 2 {
+		t.Fatalf("member object keys length should be [2], got [%v]", len(memberObjectMembers))
+	}
+}
+
+func TestPathCommentsForObject(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"bar":"foo"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"foo":"bar"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	sections := map[string]SectionHandler{
+		"goodpath": ObjectHandler(),
+	}
+
+	err = New().mergeDocs(sections, parentDoc, []*ParsedDocument{childDoc})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(parentDoc.Object.Members) != 1 {
+		t.Fatalf("parent members length should be [1], got [%v]", len(parentDoc.Object.Members))
+	}
+
+	memberIndexKey := parentDoc.Object.IndexKey(ast.TextEqual("goodpath"))
+	if memberIndexKey != 0 {
+		t.Fatalf("section index key length should be [0], got [%v]", memberIndexKey)
+	}
+
+	member := parentDoc.Object.Members[memberIndexKey]
+	memberObjectMembers := member.Value.(*jwcc.Object).Members
+	if len(memberObjectMembers) != 2 {
+		t.Fatalf("member object keys length should be [2], got [%v]", len(memberObjectMembers))
+	}
+
+	barMember := member.Value.(*jwcc.Object).Find("bar")
+	if barMember.Value.String() != "foo" {
+		t.Fatalf("member value should be [foo], got [%v]", barMember.Value.String())
+	}
+	barMemberComments := barMember.Comments().Before
+	if barMemberComments[0] != "from `parent`" {
+		t.Fatalf("member comment should be [from `parent`], got [%v]", barMemberComments[0])
+	}
+
+	fooMember := member.Value.(*jwcc.Object).Find("foo")
+	if fooMember.Value.String() != "bar" {
+		t.Fatalf("member value should be [bar], got [%v]", fooMember.Value.String())
+	}
+	fooMemberComments := fooMember.Comments().Before
+	if fooMemberComments[0] != "from `child`" {
+		t.Fatalf("member comment should be [from `child`], got [%v]", fooMemberComments[0])
+	}
+}
+
+func TestPathCommentsForArray(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"things": [{"thing1":"foo"}],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"things": [{"thing2":"bar"}],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	sections := map[string]SectionHandler{
+		"things": ArrayHandler(),
+	}
+
+	err = New().mergeDocs(sections, parentDoc, []*ParsedDocument{childDoc})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(parentDoc.Object.Members) != 1 {
+		t.Fatalf("parent members length should be [1], got [%v]", len(parentDoc.Object.Members))
+	}
+
+	thingsMember := parentDoc.Object.Find("things")
+	if thingsMember == nil {
+		t.Fatalf("section index key length should be not nil, got [%v]", thingsMember)
+	}
+
+	thingsMemberValues := thingsMember.Value.(*jwcc.Array).Values
+	if len(thingsMemberValues) != 2 {
+		t.Fatalf("members length should be [2], got [%v]", len(thingsMemberValues))
+	}
+
+	barMember := thingsMemberValues[0].(*jwcc.Object)
+	if barMember.Members[0].Key.String() != "thing1" {
+		t.Fatalf("member key should be [thing1], got [%v]", barMember.Members[0].Key.String())
+	}
+	if barMember.Members[0].Value.String() != "foo" {
+		t.Fatalf("member value should be [foo], got [%v]", barMember.Members[0].Value.String())
+	}
+	if barMember.Comments().Before[0] != "from `parent`" {
+		t.Fatalf("member comment should be [from `parent`], got [%v]", barMember.Comments().Before[0])
+	}
+
+	fooMember := thingsMemberValues[1].(*jwcc.Object)
+	if fooMember.Members[0].Key.String() != "thing2" {
+		t.Fatalf("member key should be [thing2], got [%v]", fooMember.Members[0].Key.String())
+	}
+	if fooMember.Members[0].Value.String() != "bar" {
+		t.Fatalf("member value should be [bar], got [%v]", fooMember.Members[0].Value.String())
+	}
+	if fooMember.Comments().Before[0] != "from `child`" {
+		t.Fatalf("member comment should be [from `parent`], got [%v]", fooMember.Comments().Before[0])
+	}
+}
+
+func TestExistingOrNewObject(t *testing.T) {
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"foo":"bar"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+	}
+
+	goodpathObject := existingOrNewObject(*childDoc.Object, "goodpath")
+	if len(goodpathObject.Members) != 1 {
+		t.Fatalf("object members length should be [1], got [%v]", len(goodpathObject.Members))
+	}
+
+	badpathObject := existingOrNewObject(*childDoc.Object, "badpath")
+	if len(badpathObject.Members) != 0 {
+		t.Fatalf("object members length should be [0], got [%v]", len(badpathObject.Members))
+	}
+}
+
+func TestExistingOrNewArray(t *testing.T) {
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": ["bar"]
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+	}
+
+	goodpathObject := existingOrNewArray(*childDoc.Object, "goodpath")
+	if len(goodpathObject.Values) != 1 {
+		t.Fatalf("object members length should be [1], got [%v]", len(goodpathObject.Values))
+	}
+
+	badpathObject := existingOrNewArray(*childDoc.Object, "badpath")
+	if len(badpathObject.Values) != 0 {
+		t.Fatalf("object members length should be [0], got [%v]", len(badpathObject.Values))
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"goodpath": {"bar":"foo"}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+	}
+
+	sameMembers := removeMember(parentDoc.Object, "NOTHING_TO_REMOVE")
+	if len(sameMembers) != 1 {
+		t.Fatalf("members count should be [%v], got [%v]", 1, len(sameMembers))
+	}
+
+	removedMembers := removeMember(parentDoc.Object, "goodpath")
+	if len(removedMembers) != 0 {
+		t.Fatalf("members count should be [%v], got [%v]", 0, len(removedMembers))
+	}
+}
+
+func TestGetAllowedSections(t *testing.T) {
+	actualValue := ObjectHandler()
+	defined := map[string]SectionHandler{
+		"1": actualValue,
+		"2": actualValue,
+		"3": actualValue,
+	}
+	allowed := []string{"1", "2"}
+	allowedAclSections, err := getAllowedSections(allowed, defined)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	// should exist
+	section1 := allowedAclSections["1"]
+	if section1 == nil {
+		t.Fatalf("section [%v] should NOT be [nil]", "1")
+	}
+	section2 := allowedAclSections["2"]
+	if section2 == nil {
+		t.Fatalf("section [%v] should NOT be [nil]", "2")
+	}
+
+	// should not exist
+	section3 := allowedAclSections["3"]
+	if section3 != nil {
+		t.Fatalf("section [%v] SHOULD be [nil]", "3")
+	}
+	sectionZ := allowedAclSections["Z"]
+	if sectionZ != nil {
+		t.Fatalf("section [%v] SHOULD be [nil]", "Z")
+	}
+}
+
+func TestGetAllowedSectionsInvalidSection(t *testing.T) {
+	actualValue := ObjectHandler()
+	defined := map[string]SectionHandler{
+		"1": actualValue,
+		"2": actualValue,
+		"3": actualValue,
+	}
+	allowed := []string{"1", "2", "invalid"}
+	_, err := getAllowedSections(allowed, defined)
+	if err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+}
+
+func TestGetAllowedSectionsCaseInsensitive(t *testing.T) {
+	allowed := []string{"ACLs", "randomizeclientport"}
+	allowedAclSections, err := getAllowedSections(allowed, NewRegistry())
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if allowedAclSections["acls"] == nil || allowedAclSections["randomizeClientPort"] == nil || len(allowedAclSections) != 2 {
+		t.Fatalf("sections should be keyed by canonical name, got [%v]", allowedAclSections)
+	}
+}
+
+func TestCanonicalizeSections(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{
+		"RandomizeClientPort": true,
+		"ACLs": [],
+		"unknownSection": [],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parsed := &ParsedDocument{Path: "doc", Object: doc.Value.(*jwcc.Object)}
+
+	diags := canonicalizeSections(parsed, NewRegistry())
+	if len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got [%v]", diags)
+	}
+
+	keys := []string{}
+	for _, m := range parsed.Object.Members {
+		keys = append(keys, m.Key.String())
+	}
+	if strings.Join(keys, ",") != "randomizeClientPort,acls,unknownSection" {
+		t.Fatalf("keys should be [randomizeClientPort,acls,unknownSection], got [%v]", keys)
+	}
+}
+
+func TestCanonicalizeSectionsConflict(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{
+		"randomizeClientPort": true,
+		"RandomizeClientPort": true,
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parsed := &ParsedDocument{Path: "doc", Object: doc.Value.(*jwcc.Object)}
+
+	diags := canonicalizeSections(parsed, NewRegistry())
+	expected := `doc:3:3: section ["RandomizeClientPort"] is also defined as ["randomizeClientPort"] at [doc:2]`
+	if len(diags) != 1 || diags[0].Error() != expected || diags[0].Rule != RuleConflict {
+		t.Fatalf("diagnostics should be [%v], got [%v]", expected, diags)
+	}
+}
+
+func TestCombineCaseInsensitiveSections(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"parent.hujson":          `{"ACLs": [{"action": "accept", "src": ["*"], "dst": ["*:22"]}]}`,
+		"children/acls.hujson":   `{"Acls": [{"action": "accept", "src": ["a@example.com"], "dst": ["tag:a:*"]}]}`,
+		"children/groups.hujson": `{"Groups": {"group:a": ["a@example.com"]}}`,
+	})
+
+	result, err := New(
+		WithParent(filepath.Join(dir, "parent.hujson")),
+		WithChildDir(filepath.Join(dir, "children")),
+		WithAllow("ACLS", "groups"),
+	).Combine(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(result.Policy.Members) != 2 {
+		t.Fatalf("policy members length should be [2], got [%v]", len(result.Policy.Members))
+	}
+	acls := result.Policy.FindKey(ast.TextEqual("acls"))
+	if acls == nil || len(acls.Value.(*jwcc.Array).Values) != 2 {
+		t.Fatalf("expected acls to be merged under the canonical key, got [%v]", result.Policy.Members)
+	}
+	if result.Policy.FindKey(ast.TextEqual("groups")) == nil {
+		t.Fatalf("expected groups under the canonical key, got [%v]", result.Policy.Members)
+	}
+}
+
+func TestHandleArray(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"acls": [
+			{"action": "accept", "src": ["finance1"], "dst": ["tag:demo-infra:22"]},
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	childSection := child.Value.(*jwcc.Object).Find("acls")
+
+	handlerFn := ArrayHandler()
+	handlerFn("acls", parentDoc.Path, parentDoc.Object, "CHILD", childSection)
+
+	mergedValues := parentDoc.Object.Find("acls").Value.(*jwcc.Array).Values
+	if len(mergedValues) != 2 {
+		t.Fatalf("section [%v] should be [1], not [%v]", "acls", len(mergedValues))
+	}
+}
+func TestHandleObject(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:from_child": [
+				"dave@example.com",
+				"laura@example.com",
+			],
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	childSection := child.Value.(*jwcc.Object).Find("groups")
+
+	handlerFn := ObjectHandler()
+	handlerFn("groups", parentDoc.Path, parentDoc.Object, "CHILD", childSection)
+
+	mergedValues := parentDoc.Object.Find("groups").Value.(*jwcc.Object).Members
+	if len(mergedValues) != 3 {
+		t.Fatalf("section [%v] should be [1], not [%v]", "groups", len(mergedValues))
+	}
+}
+
+func TestHandleAutoApprovers(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"autoApprovers": {
+			"routes": {
+				"10.0.1.0/24": ["group:engineering", "alice@example.com", "tag:foo"],
+			},
+			"exitNode": ["tag:foo"],
+		},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childSection := child.Value.(*jwcc.Object).Find("autoApprovers")
+
+	handlerFn := AutoApproversHandler()
+	handlerFn("autoApprovers", parentDoc.Path, parentDoc.Object, "CHILD", childSection)
+
+	mergedValues := parentDoc.Object.Find("autoApprovers").Value
+	if len(mergedValues.(*jwcc.Object).Members) != 2 {
+		t.Fatalf("section [%v] should be [2], not [%v]", "autoApprovers", len(mergedValues.(*jwcc.Object).Members))
+	}
+
+	routesValues := mergedValues.(*jwcc.Object).Find("routes").Value.(*jwcc.Object).Members
+	if len(routesValues) != 2 {
+		t.Fatalf("section [%v] should be [2], not [%v]", "routes", len(routesValues))
+	}
+
+	exitNodeValues := mergedValues.(*jwcc.Object).Find("exitNode").Value.(*jwcc.Array).Values
+	if len(exitNodeValues) != 2 {
+		t.Fatalf("section [%v] should be [2], not [%v]", "exitNode", len(exitNodeValues))
+	}
+}
+
+func TestEmptyParentObject(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{"hosts":{}}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"hosts": {
+			"host1": "100.99.98.97",
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	err = New().mergeDocs(DefaultSections(), parentDoc, []*ParsedDocument{childDoc})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	mergedValues := parentDoc.Object.Find("hosts").Value.(*jwcc.Object).Members
+	if len(mergedValues) != 1 {
+		t.Fatalf("section [%v] should be [1], not [%v]", "hosts", len(mergedValues))
+	}
+}
+
+func TestEmptyParentArray(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{"acls":[]}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"acls": [
+			{"action": "accept", "src": ["finance1"], "dst": ["tag:demo-infra:22"]},
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	err = New().mergeDocs(DefaultSections(), parentDoc, []*ParsedDocument{childDoc})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	mergedValues := parentDoc.Object.Find("acls").Value.(*jwcc.Array).Values
+	if len(mergedValues) != 1 {
+		t.Fatalf("section [%v] should be [1], not [%v]", "acls", len(mergedValues))
+	}
+}
+
+func TestEmptyChildObject(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"hosts": {
+			"host1": "100.99.98.97",
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{"hosts":{}}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	err = New().mergeDocs(DefaultSections(), parentDoc, []*ParsedDocument{childDoc})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	mergedValues := parentDoc.Object.Find("hosts").Value.(*jwcc.Object).Members
+	if len(mergedValues) != 1 {
+		t.Fatalf("section [%v] should be [1], not [%v]", "hosts", len(mergedValues))
+	}
+}
+
+func TestEmptyChildArray(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{
+		"acls": [
+			{"action": "accept", "src": ["finance1"], "dst": ["tag:demo-infra:22"]},
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{"acls":[]}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	err = New().mergeDocs(DefaultSections(), parentDoc, []*ParsedDocument{childDoc})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	mergedValues := parentDoc.Object.Find("acls").Value.(*jwcc.Array).Values
+	if len(mergedValues) != 1 {
+		t.Fatalf("section [%v] should be [1], not [%v]", "acls", len(mergedValues))
+	}
+}
+
+func TestSort(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"autoApprovers": {
+			"routes": {
+				"10.0.1.0/24": ["group:engineering", "alice@example.com", "tag:foo"],
+			},
+			"exitNode": ["tag:foo"],
+		},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childDoc := &ParsedDocument{
+		Object: child.Value.(*jwcc.Object),
+		Path:   "child",
+	}
+
+	err = New().mergeDocs(DefaultSections(), parentDoc, []*ParsedDocument{childDoc})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	expectedSort := []string{"acls", "autoApprovers", "groups", "tagOwners"}
+	for i, v := range expectedSort {
+		if parentDoc.Object.Members[i].Key.String() != v {
+			t.Fatalf("section [%v] should be position [%v]", v, i)
+		}
+	}
+}
+
+func printDocument(doc *ParsedDocument) {
+	err := jwcc.Format(os.Stdout, doc.Object)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestHandleGroups(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(ACL_PARENT))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:engineering": [
+				"dave@example.com",
+				"carol@example.com",
+			],
+			"group:from_child": [
+				"erin@example.com",
+			],
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	childSection := child.Value.(*jwcc.Object).Find("groups")
+
+	handlerFn := GroupsHandler()
+	handlerFn("groups", parentDoc.Path, parentDoc.Object, "CHILD", childSection)
+
+	mergedValues := parentDoc.Object.Find("groups").Value.(*jwcc.Object).Members
+	if len(mergedValues) != 3 {
+		t.Fatalf("section [%v] should be [3], not [%v]", "groups", len(mergedValues))
+	}
+
+	engineering := parentDoc.Object.Find("groups").Value.(*jwcc.Object).Find("group:engineering")
+	engineeringValues := engineering.Value.(*jwcc.Array).Values
+	expectedUsers := []string{"dave@example.com", "laura@example.com", "carol@example.com"}
+	if len(engineeringValues) != len(expectedUsers) {
+		t.Fatalf("group [%v] should have [%v] members, not [%v]", "group:engineering", len(expectedUsers), len(engineeringValues))
+	}
+	for i, v := range expectedUsers {
+		if engineeringValues[i].String() != v {
+			t.Fatalf("group member [%v] should be [%v], got [%v]", i, v, engineeringValues[i].String())
+		}
+	}
+
+	if engineeringValues[2].Comments().Before[0] != "from `CHILD`" {
+		t.Fatalf("member comment should be [from `CHILD`], got [%v]", engineeringValues[2].Comments().Before)
+	}
+}
+
+func TestHandleGroupsDuplicateAcrossChildren(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	childDocs := []*ParsedDocument{}
+	for i, users := range []string{`["user1", "user2"]`, `["user2", "user3"]`} {
+		child, err := jwcc.Parse(strings.NewReader(`{"groups": {"group:eng": ` + users + `}}`))
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		childDocs = append(childDocs, &ParsedDocument{
+			Object: child.Value.(*jwcc.Object),
+			Path:   fmt.Sprintf("child%d", i),
+		})
+	}
+
+	err = New().mergeDocs(map[string]SectionHandler{"groups": GroupsHandler()}, parentDoc, childDocs)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	groups := parentDoc.Object.Find("groups").Value.(*jwcc.Object).Members
+	if len(groups) != 1 {
+		t.Fatalf("section [%v] should be [1], not [%v]", "groups", len(groups))
+	}
+
+	users := groups[0].Value.(*jwcc.Array).Values
+	if len(users) != 3 {
+		t.Fatalf("group [%v] should have [3] members, not [%v]", "group:eng", len(users))
+	}
+	if groups[0].Comments().Before[0] != "from `child0`" {
+		t.Fatalf("member comment should be [from `child0`], got [%v]", groups[0].Comments().Before)
+	}
+	if users[2].Comments().Before[0] != "from `child1`" {
+		t.Fatalf("member comment should be [from `child1`], got [%v]", users[2].Comments().Before)
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`[
+		// from ` + "`a.hujson`" + `
+		1,
+		// unrelated
+		2,
+	]`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	values := doc.Value.(*jwcc.Array).Values
+
+	path, ok := Provenance(values[0])
+	if !ok || path != "a.hujson" {
+		t.Fatalf("provenance should be [a.hujson], got [%v]", path)
+	}
+	if _, ok := Provenance(values[1]); ok {
+		t.Fatalf("provenance should not be found for [%v]", values[1])
+	}
+
+	pathComment(values[1], "b.hujson")
+	path, ok = Provenance(values[1])
+	if !ok || path != "b.hujson" {
+		t.Fatalf("provenance should be [b.hujson], got [%v]", path)
+	}
+}
+
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+	}
+	return dir
+}
+
+func TestCombine(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"parent.hujson":              `{"acls": [{"action": "accept", "src": ["*"], "dst": ["*:22"]}]}`,
+		"children/a/acls.hujson":     `{"acls": [{"action": "accept", "src": ["a@example.com"], "dst": ["tag:a:*"]}]}`,
+		"children/b/ipsets.hujson":   `{"ipsets": {"ipset:b": ["192.0.2.0/24"]}}`,
+		"children/b/ignored.txt":     `not a policy file`,
+		"elsewhere/unrelated.hujson": `{"unrelated": true}`,
+	})
+
+	var logs bytes.Buffer
+	c := New(
+		WithParent(filepath.Join(dir, "parent.hujson")),
+		WithChildDir(filepath.Join(dir, "children")),
+		WithAllow("acls", "ipsets"),
+		WithLogger(log.New(&logs, "", 0)),
+	)
+	result, err := c.Combine(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	acls := result.Policy.Find("acls").Value.(*jwcc.Array).Values
+	if len(acls) != 2 {
+		t.Fatalf("acls length should be [2], got [%v]", len(acls))
+	}
+	if result.Policy.Find("ipsets") == nil {
+		t.Fatalf("expected ipsets to be merged")
+	}
+	if result.Policy.Find("unrelated") != nil {
+		t.Fatalf("expected files outside the child directory to be ignored")
+	}
+	if len(result.Warnings) != 0 {
+		t.Fatalf("expected no warnings, got [%v]", result.Warnings)
+	}
+	if !strings.Contains(logs.String(), "walking path") {
+		t.Fatalf("expected progress to be logged, got [%v]", logs.String())
+	}
+}
+
+func TestCombineErrorsIncludeWarnings(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"parent.hujson":         `{"hosts": {"a": "100.64.0.1"}}`,
+		"children/hosts.hujson": `{"hosts": {"a": "100.64.0.2"}, "acls": []}`,
+	})
+
+	c := New(
+		WithParent(filepath.Join(dir, "parent.hujson")),
+		WithChildDir(filepath.Join(dir, "children")),
+		WithAllow("hosts"),
+		WithDuplicates(DuplicatePolicies{"hosts": DuplicateWarn}),
+	)
+	_, err := c.Combine(context.Background())
+
+	ds := CollectDiagnostics(err)
+	if len(ds) != 2 || ds[0].Severity != SeverityWarning || ds[1].Rule != RuleUnsupportedSection {
+		t.Fatalf("expected a warning and an error, got [%v]", ds)
+	}
+}
+
+func TestCombineGroupsWarn(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"parent.hujson":          `{"groups": {"group:a": ["alice@example.com"]}}`,
+		"children/groups.hujson": `{"groups": {"group:a": ["bob@example.com"]}}`,
+	})
+
+	result, err := New(
+		WithParent(filepath.Join(dir, "parent.hujson")),
+		WithChildDir(filepath.Join(dir, "children")),
+		WithAllow("groups"),
+		WithDuplicates(DuplicatePolicies{"groups": DuplicateWarn}),
+	).Combine(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Rule != RuleDuplicateKey {
+		t.Fatalf("expected a duplicate key warning, got [%v]", result.Warnings)
+	}
+
+	// Groups are combined whatever the duplicate policy.
+	groups := result.Policy.Find("groups").Value.(*jwcc.Object)
+	expected := `{"group:a":["alice@example.com","bob@example.com"]}`
+	if len(groups.Members) != 1 || groups.Undecorate().JSON() != expected {
+		t.Fatalf("groups should be [%s], got [%s]", expected, groups.Undecorate().JSON())
+	}
+}
+
+func TestCombineSectionOrder(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"children/a.hujson": `{"tagOwners": [], "hosts": [], "ipsets": [], "postures": []}`,
+	})
+
+	// Handlers run in sorted order, so their diagnostics are in the same
+	// order on every run.
+	for range 20 {
+		_, err := New(
+			WithChildDir(filepath.Join(dir, "children")),
+			WithAllow("hosts", "ipsets", "postures", "tagOwners"),
+		).Combine(context.Background())
+		messages := []string{}
+		for _, d := range CollectDiagnostics(err) {
+			messages = append(messages, d.Message)
+		}
+		expected := []string{
+			`section ["hosts"] must be an object`,
+			`section ["ipsets"] must be an object`,
+			`section ["postures"] must be an object`,
+			`section ["tagOwners"] must be an object`,
+		}
+		if !slices.Equal(messages, expected) {
+			t.Fatalf("expected diagnostics %v, got %v", expected, messages)
+		}
+	}
+}
+
+func TestCombineCollectsEarlyErrors(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"parent.hujson":                 `{"acls": [`,
+		"children/.acl-combiner.hujson": `{"allow": {"*": ["acls"]}, "unknown": true}`,
+		"children/acls.hujson":          `{"acls": []}`,
+		"other/hosts.hujson":            `{"hosts": {"a": "100.64.0.1"}, "groups": {}}`,
+	})
+
+	_, err := New(
+		WithParent(filepath.Join(dir, "parent.hujson")),
+		WithChildDir(filepath.Join(dir, "children")),
+		WithChildDir(filepath.Join(dir, "other")),
+		WithAllow("hosts", "bogus"),
+	).Combine(context.Background())
+
+	ds := CollectDiagnostics(err)
+	rules := []string{}
+	for _, d := range ds {
+		rules = append(rules, d.Rule)
+	}
+	// The parent, the config and the flag are reported along with the
+	// children that could still be checked.
+	expected := []string{RuleParse, RuleInvalidConfig, RuleInvalidArgument, RuleUnsupportedSection}
+	if !slices.Equal(rules, expected) {
+		t.Fatalf("expected diagnostics for rules %v, got [%v]", expected, ds)
+	}
+	if ds[3].Path != filepath.Join(dir, "other", "hosts.hujson") {
+		t.Fatalf("expected the unsupported section in [hosts.hujson], got [%v]", ds[3])
+	}
+}
+
+func TestCombineCanceled(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"children/acls.hujson": `{"acls": []}`,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := New(WithChildDir(filepath.Join(dir, "children")), WithAllow("acls")).Combine(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected [%v], got [%v]", context.Canceled, err)
+	}
+}
+
+func TestCombineChildSources(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"departments/eng.hujson": `{"acls": [{"action": "accept", "src": ["group:eng"], "dst": ["tag:eng:*"]}]}`,
+		"environments/prod.json": `{"acls": [{"action": "accept", "src": ["group:sre"], "dst": ["tag:prod:*"]}]}`,
+		"extra/finance.hujson":   `{"acls": [{"action": "accept", "src": ["group:finance"], "dst": ["tag:finance:*"]}]}`,
+		"extra/README.md":        `not a policy`,
+	})
+
+	result, err := New(
+		WithChildDir(filepath.Join(dir, "departments")),
+		WithChildDir(filepath.Join(dir, "environments")),
+		WithChildFiles(filepath.Join(dir, "extra", "finance.hujson"), filepath.Join(dir, "extra", "README.md")),
+		WithAllow("acls"),
+	).Combine(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	acls := result.Policy.Find("acls").Value.(*jwcc.Array).Values
+	if len(acls) != 3 {
+		t.Fatalf("acls length should be [3], got [%v]", len(acls))
+	}
+	for i, path := range []string{"departments/eng.hujson", "environments/prod.json", "extra/finance.hujson"} {
+		if from, _ := Provenance(acls[i]); from != filepath.Join(dir, path) {
+			t.Fatalf("acl [%d] should be from [%s], got [%s]", i, path, from)
+		}
+	}
+}
+
+func TestCombineChildSourcesDuplicate(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"departments/eng/acls.hujson": `{"acls": []}`,
+	})
+	path := filepath.Join(dir, "departments", "eng", "acls.hujson")
+
+	_, err := New(
+		WithChildDir(filepath.Join(dir, "departments")),
+		WithChildDir(filepath.Join(dir, "departments", "eng")),
+		WithChildFiles(path, path),
+		WithAllow("acls"),
+	).Combine(context.Background())
+	ds := CollectDiagnostics(err)
+	expected := []string{
+		path + ": [" + path + "] is included more than once, under [" + filepath.Join(dir, "departments") + "] and under [" + filepath.Join(dir, "departments", "eng") + "]",
+		path + ": [" + path + "] is included more than once, under [" + filepath.Join(dir, "departments") + "] and in the file list",
+		path + ": [" + path + "] is included more than once, under [" + filepath.Join(dir, "departments") + "] and in the file list",
+	}
+	if len(ds) != len(expected) {
+		t.Fatalf("expected [%d] errors, got [%v]", len(expected), ds)
+	}
+	for i, d := range ds {
+		if d.Error() != expected[i] || d.Rule != RuleDuplicateFile {
+			t.Fatalf("expected error [%v], got [%v]", expected[i], d)
+		}
+	}
+}
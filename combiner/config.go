@@ -0,0 +1,355 @@
+package combiner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// DefaultConfigFile is the name of the config file looked for at the root of
+// the child directory when no config file is provided.
+const DefaultConfigFile = ".acl-combiner.hujson"
+
+// combinerConfig is loaded from a config file such as:
+//
+//	{
+//		"allow": {
+//			"departments/finance/**":  ["acls"],
+//			"departments/platform/**": ["acls", "groups", "autoApprovers"],
+//		},
+//		"namespaces": {
+//			"departments/finance/**": ["tag:finance", "tag:finance-*", "group:finance"],
+//		},
+//		"sections": {
+//			"newSection": "array",
+//		},
+//		"order": ["departments/platform/**", "departments/**"],
+//	}
+//
+// Globs are matched against child file paths relative to the directory
+// containing the config file.
+type combinerConfig struct {
+	Path string
+	// Allow maps globs to the sections allowed from matching children.
+	Allow []globRule
+	// Namespaces maps globs to the tags, groups and other selectors owned by
+	// matching children.
+	Namespaces []globRule
+	// Sections declares sections that are not built in, along with the
+	// strategy used to merge them, in the order they appear.
+	Sections []sectionDeclaration
+	// Order lists globs in the order matching children are merged in with
+	// OrderConfig.
+	Order []string
+}
+
+type sectionDeclaration struct {
+	Name     string
+	Strategy MergeStrategy
+}
+
+type globRule struct {
+	Glob   string
+	Values []string
+}
+
+// ConfigPath returns the config file from WithConfig, or else the first
+// config file found for the child files as described by WithConfig, or "" if
+// there is none.
+func (c *Combiner) ConfigPath() string {
+	if c.configPath != "" {
+		return c.configPath
+	}
+	for _, dir := range c.childDirs {
+		if path := existingFile(filepath.Join(dir, DefaultConfigFile)); path != "" {
+			return path
+		}
+	}
+	for _, path := range c.childFileList {
+		if configPath := nearestConfigFile(path); configPath != "" {
+			return configPath
+		}
+	}
+	return ""
+}
+
+// childConfigPath returns the config file that applies to the child file f,
+// or "" if there is none.
+func (c *Combiner) childConfigPath(f ChildFile) string {
+	if c.configPath != "" {
+		return c.configPath
+	}
+	if f.sourceIndex < len(c.childDirs) {
+		return existingFile(filepath.Join(c.childDirs[f.sourceIndex], DefaultConfigFile))
+	}
+	return nearestConfigFile(f.Path)
+}
+
+// nearestConfigFile returns DefaultConfigFile in the closest directory
+// containing path that has one, or "" if none does.
+func nearestConfigFile(path string) string {
+	for _, dir := range ancestorDirs(path) {
+		if configPath := existingFile(filepath.Join(dir, DefaultConfigFile)); configPath != "" {
+			return configPath
+		}
+	}
+	return ""
+}
+
+// existingFile returns path if there is a file there, or "" otherwise.
+func existingFile(path string) string {
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		return path
+	}
+	return ""
+}
+
+// loadConfigs loads the config file from ConfigPath and those that apply to
+// docs, keyed by path, and registers the sections they declare in sections.
+func (c *Combiner) loadConfigs(docs []*ParsedDocument, sections Registry) (map[string]*combinerConfig, error) {
+	paths := []string{}
+	if path := c.ConfigPath(); path != "" {
+		paths = append(paths, path)
+	}
+	for _, doc := range docs {
+		if doc.configPath != "" && !slices.Contains(paths, doc.configPath) {
+			paths = append(paths, doc.configPath)
+		}
+	}
+	slices.Sort(paths)
+
+	configs := map[string]*combinerConfig{}
+	declared := map[string]*combinerConfig{}
+	var diags Diagnostics
+	for _, path := range paths {
+		config, err := c.loadConfig(path)
+		if err != nil {
+			diags = append(diags, CollectDiagnostics(err)...)
+			continue
+		}
+		configs[path] = config
+
+		for _, d := range config.Sections {
+			key := strings.ToLower(d.Name)
+			if other, ok := declared[key]; ok {
+				i := slices.IndexFunc(other.Sections, func(o sectionDeclaration) bool { return strings.EqualFold(o.Name, d.Name) })
+				if other.Sections[i].Strategy != d.Strategy {
+					diags = append(diags, Diagnostic{Path: path, Severity: SeverityError, Rule: RuleInvalidConfig, Message: fmt.Sprintf("invalid config: section [%s] is declared as [%s] in [%s]", d.Name, other.Sections[i].Strategy, other.Path)})
+					continue
+				}
+			}
+			declared[key] = config
+			c.logf("declaring section [%s] merged as [%s]\n", d.Name, d.Strategy)
+			err = sections.RegisterStrategy(d.Name, d.Strategy)
+			if err != nil {
+				diags = append(diags, CollectDiagnostics(err)...)
+			}
+		}
+	}
+	return configs, diags.err()
+}
+
+func (c *Combiner) loadConfig(path string) (*combinerConfig, error) {
+	c.logf("loading config [%v]...\n", path)
+
+	doc, err := Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &combinerConfig{Path: path}
+	var allowMember *jwcc.Member
+	for _, m := range doc.Object.Members {
+		switch m.Key.String() {
+		case "allow":
+			allowMember = m
+			config.Allow, err = globRules(m)
+		case "namespaces":
+			config.Namespaces, err = globRules(m)
+		case "sections":
+			config.Sections, err = c.sectionDeclarations(m)
+		case "order":
+			config.Order, err = stringValues(m.Value)
+			if err != nil {
+				err = fmt.Errorf("[%s] %v", m.Key, err)
+			}
+		default:
+			err = fmt.Errorf("unsupported key [%s]", m.Key)
+		}
+		if err != nil {
+			return nil, errorAt(path, m, RuleInvalidConfig, "invalid config: %v", err)
+		}
+	}
+
+	// Sections may be allowed before they are declared in the file.
+	for _, rule := range config.Allow {
+		for _, section := range rule.Values {
+			if _, ok := c.sections.canonical(section); !ok && !config.declares(section) {
+				return nil, errorAt(path, allowMember, RuleInvalidConfig, "invalid config: unsupported section [%s] in [allow.%s]", section, rule.Glob)
+			}
+		}
+	}
+	return config, nil
+}
+
+// sectionDeclarations returns the sections declared in m, which maps section
+// names to one of MergeStrategies.
+func (c *Combiner) sectionDeclarations(m *jwcc.Member) ([]sectionDeclaration, error) {
+	obj, ok := m.Value.(*jwcc.Object)
+	if !ok {
+		return nil, fmt.Errorf("[%s] must be an object", m.Key)
+	}
+
+	declarations := []sectionDeclaration{}
+	for _, section := range obj.Members {
+		name := section.Key.String()
+		if _, ok := c.sections.canonical(name); ok {
+			return nil, fmt.Errorf("[%s.%s] section is already defined", m.Key, name)
+		}
+		text, ok := section.Value.Undecorate().(ast.Text)
+		if !ok {
+			return nil, fmt.Errorf("[%s.%s] must be one of %v", m.Key, name, MergeStrategies)
+		}
+		strategy := MergeStrategy(text.String())
+		if _, err := strategyHandler(strategy); err != nil {
+			return nil, fmt.Errorf("[%s.%s] %v", m.Key, name, err)
+		}
+		declarations = append(declarations, sectionDeclaration{Name: name, Strategy: strategy})
+	}
+	return declarations, nil
+}
+
+func (c *combinerConfig) declares(section string) bool {
+	for _, d := range c.Sections {
+		if strings.EqualFold(d.Name, section) {
+			return true
+		}
+	}
+	return false
+}
+
+func globRules(m *jwcc.Member) ([]globRule, error) {
+	obj, ok := m.Value.(*jwcc.Object)
+	if !ok {
+		return nil, fmt.Errorf("[%s] must be an object", m.Key)
+	}
+
+	rules := []globRule{}
+	for _, rule := range obj.Members {
+		values, err := stringValues(rule.Value)
+		if err != nil {
+			return nil, fmt.Errorf("[%s.%s] %v", m.Key, rule.Key, err)
+		}
+		rules = append(rules, globRule{Glob: rule.Key.String(), Values: values})
+	}
+	return rules, nil
+}
+
+// allowedSections returns the sections allowed for childPath by the first
+// matching glob, in the order they appear in the config file.
+func (c *combinerConfig) allowedSections(childPath string) ([]string, bool) {
+	return c.match(c.Allow, childPath)
+}
+
+// namespace returns the selectors owned by childPath according to the first
+// matching glob, in the order they appear in the config file.
+func (c *combinerConfig) namespace(childPath string) ([]string, bool) {
+	return c.match(c.Namespaces, childPath)
+}
+
+// order returns the index of the first glob under "order" matching
+// childPath along with the glob, or len(c.Order) if there is none.
+func (c *combinerConfig) order(childPath string) (int, string) {
+	rel, ok := c.rel(childPath)
+	if ok {
+		for i, glob := range c.Order {
+			if matchGlob(glob, rel) {
+				return i, glob
+			}
+		}
+	}
+	return len(c.Order), ""
+}
+
+func (c *combinerConfig) match(rules []globRule, childPath string) ([]string, bool) {
+	rel, ok := c.rel(childPath)
+	if !ok {
+		return nil, false
+	}
+
+	for _, rule := range rules {
+		if matchGlob(rule.Glob, rel) {
+			return rule.Values, true
+		}
+	}
+	return nil, false
+}
+
+// matchGlob reports whether name matches pattern. Path segments are matched
+// with path.Match, and a "**" segment matches any number of segments.
+func matchGlob(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// rel returns childPath relative to the directory containing the config
+// file, using forward slashes, or false if childPath is outside it. Either
+// path may be relative to the working directory.
+func (c *combinerConfig) rel(childPath string) (string, bool) {
+	rel, err := filepath.Rel(filepath.Dir(absPath(c.Path)), absPath(childPath))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// stringValues returns the strings in v, which must be an array of strings.
+func stringValues(v jwcc.Value) ([]string, error) {
+	arr, ok := v.(*jwcc.Array)
+	if !ok {
+		return nil, errors.New("must be an array of strings")
+	}
+
+	values := []string{}
+	for _, item := range arr.Values {
+		s, ok := item.(*jwcc.Datum)
+		if !ok {
+			return nil, errors.New("must be an array of strings")
+		}
+		text, ok := s.Value.(ast.Text)
+		if !ok {
+			return nil, errors.New("must be an array of strings")
+		}
+		values = append(values, text.String())
+	}
+	return values, nil
+}
@@ -0,0 +1,210 @@
+package combiner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"departments/finance/**", "departments/finance/acls.hujson", true},
+		{"departments/finance/**", "departments/finance/team/acls.hujson", true},
+		{"departments/finance/**", "departments/engineering/acls.hujson", false},
+		{"departments/*/acls.hujson", "departments/finance/acls.hujson", true},
+		{"departments/*/acls.hujson", "departments/finance/team/acls.hujson", false},
+		{"**/groups.hujson", "departments/platform/groups.hujson", true},
+		{"**", "acls.hujson", true},
+	}
+	for _, c := range cases {
+		if got := matchGlob(c.pattern, c.name); got != c.want {
+			t.Fatalf("pattern [%v] name [%v] should be [%v], got [%v]", c.pattern, c.name, c.want, got)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, DefaultConfigFile)
+	err := os.WriteFile(path, []byte(`{
+		"allow": {
+			"departments/finance/**": ["acls"],
+			"departments/**":         ["acls", "groups"],
+		},
+		"namespaces": {
+			"departments/finance/**": ["tag:finance", "group:finance"],
+		},
+	}`), 0o644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	config, err := New().loadConfig(path)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	allowed, ok := config.allowedSections(filepath.Join(dir, "departments", "finance", "acls.hujson"))
+	if !ok || strings.Join(allowed, ",") != "acls" {
+		t.Fatalf("allowed sections should be [acls], got [%v]", allowed)
+	}
+
+	allowed, ok = config.allowedSections(filepath.Join(dir, "departments", "platform", "groups.hujson"))
+	if !ok || strings.Join(allowed, ",") != "acls,groups" {
+		t.Fatalf("allowed sections should be [acls,groups], got [%v]", allowed)
+	}
+
+	_, ok = config.allowedSections(filepath.Join(dir, "other", "acls.hujson"))
+	if ok {
+		t.Fatalf("allowed sections should not be found for [other/acls.hujson]")
+	}
+
+	namespace, ok := config.namespace(filepath.Join(dir, "departments", "finance", "acls.hujson"))
+	if !ok || strings.Join(namespace, ",") != "tag:finance,group:finance" {
+		t.Fatalf("namespace should be [tag:finance,group:finance], got [%v]", namespace)
+	}
+
+	_, ok = config.namespace(filepath.Join(dir, "departments", "platform", "groups.hujson"))
+	if ok {
+		t.Fatalf("namespace should not be found for [departments/platform/groups.hujson]")
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for _, src := range []string{
+		`{"allow": ["acls"]}`,
+		`{"allow": {"**": "acls"}}`,
+		`{"unknown": {}}`,
+		`{"allow": {"**": ["unknownSection"]}}`,
+		`{"sections": ["newSection"]}`,
+		`{"sections": {"acls": "array"}}`,
+		`{"sections": {"newSection": "invalid"}}`,
+		`{"sections": {"newSection": ["array"]}}`,
+	} {
+		path := filepath.Join(t.TempDir(), DefaultConfigFile)
+		err := os.WriteFile(path, []byte(src), 0o644)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+
+		_, err = New().loadConfig(path)
+		if err == nil {
+			t.Fatalf("expected error for [%v], got [%v]", src, err)
+		}
+	}
+}
+
+func TestLoadConfigSections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultConfigFile)
+	err := os.WriteFile(path, []byte(`{
+		"allow": {
+			"**": ["acls", "newArray"],
+		},
+		"sections": {
+			"newArray":  "array",
+			"newObject": "union",
+		},
+	}`), 0o644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	config, err := New().loadConfig(path)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	expected := []sectionDeclaration{{Name: "newArray", Strategy: MergeArray}, {Name: "newObject", Strategy: MergeUnion}}
+	if fmt.Sprint(config.Sections) != fmt.Sprint(expected) {
+		t.Fatalf("sections should be [%v], got [%v]", expected, config.Sections)
+	}
+}
+
+func TestMergeDocsChildSections(t *testing.T) {
+	parentDoc, childDocs := parseTestDocs(t,
+		`{}`,
+		`{"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}]}`,
+		`{"groups": {"group:platform": ["alice@example.com"]}}`,
+	)
+
+	childDocs[1].Sections = map[string]SectionHandler{
+		"acls":   ArrayHandler(),
+		"groups": GroupsHandler(),
+	}
+
+	sections := map[string]SectionHandler{"acls": ArrayHandler()}
+	err := New().mergeDocs(sections, parentDoc, childDocs)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if parentDoc.Object.Find("groups") == nil {
+		t.Fatalf("section [%v] should NOT be [nil]", "groups")
+	}
+
+	parentDoc, childDocs = parseTestDocs(t,
+		`{}`,
+		`{"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}]}`,
+	)
+	childDocs[0].Sections = map[string]SectionHandler{"groups": GroupsHandler()}
+	err = New().mergeDocs(sections, parentDoc, childDocs)
+	if err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+}
+
+func TestStringValues(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`["a", "b"]`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	values, err := stringValues(doc.Value)
+	if err != nil || strings.Join(values, ",") != "a,b" {
+		t.Fatalf("values should be [a,b], got [%v] [%v]", values, err)
+	}
+}
+
+func TestCombineConfigRelativePaths(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"kids/" + DefaultConfigFile: `{"allow": {"**": ["acls"]}}`,
+		"kids/a.hujson":             `{"groups": {"group:a": ["a@example.com"]}}`,
+		"other/b.hujson":            `{"acls": []}`,
+	})
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	relDir, err := filepath.Rel(cwd, dir)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	// The config file applies the same way however the paths are given.
+	for _, paths := range [][2]string{
+		{filepath.Join(dir, "kids", DefaultConfigFile), filepath.Join(relDir, "kids")},
+		{filepath.Join(relDir, "kids", DefaultConfigFile), filepath.Join(dir, "kids")},
+		{filepath.Join(relDir, "kids", DefaultConfigFile), filepath.Join(relDir, "kids")},
+	} {
+		_, err := New(WithConfig(paths[0]), WithChildDir(paths[1]), WithAllow("groups")).Combine(context.Background())
+		ds := CollectDiagnostics(err)
+		if len(ds) != 1 || ds[0].Rule != RuleUnsupportedSection {
+			t.Fatalf("config [%s] with children [%s] should reject groups, got [%v]", paths[0], paths[1], err)
+		}
+	}
+
+	// A child outside the directory of the config file is reported rather
+	// than merged with -allow.
+	_, err = New(WithConfig(filepath.Join(dir, "kids", DefaultConfigFile)), WithChildDir(filepath.Join(relDir, "other")), WithAllow("acls")).Combine(context.Background())
+	expected := fmt.Sprintf("%s: [%[1]s] is outside the directory of config file [%s]", filepath.Join(relDir, "other", "b.hujson"), filepath.Join(dir, "kids", DefaultConfigFile))
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error [%v], got [%v]", expected, err)
+	}
+}
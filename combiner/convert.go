@@ -0,0 +1,469 @@
+package combiner
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// layout writes HuJSON converted from another format, placing each value on
+// the line it came from, and at the same column where there is room, so that
+// the locations reported by jwcc.Parse point into the original file.
+type layout struct {
+	buf bytes.Buffer
+	// line and column are the 1-based position of the next byte written.
+	line   int
+	column int
+	// commented is set if the current line ends with a comment.
+	commented bool
+}
+
+func newLayout() *layout {
+	return &layout{line: 1, column: 1}
+}
+
+// moveTo moves to line and column, or as close as it can without going back.
+func (l *layout) moveTo(line int, column int) {
+	if line > l.line {
+		l.buf.WriteString(strings.Repeat("\n", line-l.line))
+		l.line = line
+		l.column = 1
+		l.commented = false
+	}
+	if line == l.line && column > l.column {
+		l.buf.WriteString(strings.Repeat(" ", column-l.column))
+		l.column = column
+	}
+}
+
+// write writes s, which must not contain newlines.
+func (l *layout) write(s string) {
+	l.buf.WriteString(s)
+	l.column += len(s)
+}
+
+// comments writes lines as comments on the lines before line, if they are
+// still empty.
+func (l *layout) comments(lines []string, line int, column int) {
+	start := line - len(lines)
+	if len(lines) == 0 || start < l.line || (start == l.line && l.column > 1) {
+		return
+	}
+	for i, text := range lines {
+		l.moveTo(line-len(lines)+i, column)
+		l.write("// " + text)
+		l.commented = true
+	}
+}
+
+// lineComment writes text as a comment at the end of the current line, so
+// that the next value written starts on a later line.
+func (l *layout) lineComment(text string) {
+	l.write(" // " + text)
+	l.buf.WriteByte('\n')
+	l.line++
+	l.column = 1
+}
+
+func (l *layout) writeJSON(v any) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v)
+	if err != nil {
+		return err
+	}
+	l.write(strings.TrimSuffix(buf.String(), "\n"))
+	return nil
+}
+
+// yamlLine matches the line number in errors from yaml.Unmarshal.
+var yamlLine = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// convertYAML returns the YAML document in data as HuJSON, keeping the
+// position and comments of each value.
+func convertYAML(path string, data []byte) ([]byte, error) {
+	var doc yaml.Node
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		d := Diagnostic{Path: path, Severity: SeverityError, Rule: RuleParse, Message: err.Error()}
+		if m := yamlLine.FindStringSubmatch(err.Error()); m != nil {
+			d.Line, _ = strconv.Atoi(m[1])
+			d.Message = strings.TrimPrefix(err.Error(), m[0])
+		}
+		return nil, d
+	}
+	if len(doc.Content) == 0 {
+		return []byte("{}"), nil
+	}
+
+	// The comments at the top of the file are on the document, the root
+	// node or its first key, depending on blank lines, and all of them go
+	// before the root object.
+	root := doc.Content[0]
+	head := []string{doc.HeadComment, root.HeadComment}
+	if root.Kind == yaml.MappingNode && root.Style&yaml.FlowStyle == 0 && len(root.Content) > 0 {
+		head = append(head, root.Content[0].HeadComment)
+		root.Content[0].HeadComment = ""
+	}
+	l := newLayout()
+	l.comments(yamlComments(strings.TrimSpace(strings.Join(head, "\n"))), root.Line, 1)
+	err = l.yamlNode(root)
+	if err != nil {
+		return nil, Diagnostic{Path: path, Severity: SeverityError, Rule: RuleParse, Message: err.Error()}
+	}
+	return l.buf.Bytes(), nil
+}
+
+func (l *layout) yamlNode(n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.MappingNode:
+		entries, err := yamlEntries(n)
+		if err != nil {
+			return err
+		}
+		l.yamlOpen(n, "{")
+		for _, entry := range entries {
+			key, value := entry[0], entry[1]
+			l.comments(yamlComments(key.HeadComment), key.Line, key.Column)
+			l.moveTo(key.Line, key.Column)
+			err := l.writeJSON(key.Value)
+			if err != nil {
+				return err
+			}
+			l.write(":")
+			err = l.yamlNode(value)
+			if err != nil {
+				return err
+			}
+			l.write(",")
+			if comment := cmp.Or(value.LineComment, key.LineComment); comment != "" && value.Kind == yaml.ScalarNode {
+				l.lineComment(strings.Join(yamlComments(comment), " "))
+			}
+		}
+		l.write("}")
+	case yaml.SequenceNode:
+		l.yamlOpen(n, "[")
+		for _, item := range n.Content {
+			l.comments(yamlComments(item.HeadComment), item.Line, item.Column)
+			err := l.yamlNode(item)
+			if err != nil {
+				return err
+			}
+			l.write(",")
+			if item.LineComment != "" && item.Kind == yaml.ScalarNode {
+				l.lineComment(strings.Join(yamlComments(item.LineComment), " "))
+			}
+		}
+		l.write("]")
+	case yaml.AliasNode:
+		return l.yamlNode(n.Alias)
+	case yaml.ScalarNode:
+		l.moveTo(n.Line, n.Column)
+		var v any
+		err := n.Decode(&v)
+		if err != nil {
+			return err
+		}
+		if t, ok := v.(time.Time); ok {
+			v = t.Format(time.RFC3339Nano)
+		}
+		if f, ok := v.(float64); ok && (math.IsInf(f, 0) || math.IsNaN(f)) {
+			return fmt.Errorf("line %d: unsupported value [%s]", n.Line, n.Value)
+		}
+		return l.writeJSON(v)
+	default:
+		return fmt.Errorf("line %d: unsupported YAML node", n.Line)
+	}
+	return nil
+}
+
+// yamlEntries returns the keys and values of the mapping n, with merge keys
+// such as "<<: *base" replaced by the entries of the mappings they refer to,
+// as yaml.v3 does when decoding. Keys set in n itself override merged keys,
+// and earlier merged mappings override later ones.
+func yamlEntries(n *yaml.Node) ([][2]*yaml.Node, error) {
+	explicit := map[string]bool{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].ShortTag() != "!!merge" {
+			explicit[n.Content[i].Value] = true
+		}
+	}
+
+	entries := [][2]*yaml.Node{}
+	merged := map[string]bool{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		if key.ShortTag() != "!!merge" {
+			entries = append(entries, [2]*yaml.Node{key, value})
+			continue
+		}
+
+		sources := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			sources = value.Content
+		}
+		for _, source := range sources {
+			if source.Kind == yaml.AliasNode {
+				source = source.Alias
+			}
+			if source.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("line %d: merge key must refer to a mapping", key.Line)
+			}
+			sourceEntries, err := yamlEntries(source)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range sourceEntries {
+				if name := entry[0].Value; !explicit[name] && !merged[name] {
+					merged[name] = true
+					entries = append(entries, entry)
+				}
+			}
+		}
+	}
+	return entries, nil
+}
+
+// yamlOpen writes the bracket opening the mapping or sequence n. The position
+// of a block mapping or sequence is that of its first entry, so the bracket
+// goes on the line before it and its comments, which is usually that of the
+// key it is the value of.
+func (l *layout) yamlOpen(n *yaml.Node, bracket string) {
+	if n.Style&yaml.FlowStyle != 0 || len(n.Content) == 0 {
+		l.moveTo(n.Line, n.Column)
+	} else {
+		first := n.Content[0]
+		l.moveTo(first.Line-len(yamlComments(first.HeadComment))-1, n.Column)
+	}
+	if l.commented {
+		l.moveTo(l.line+1, 1)
+	}
+	l.write(bracket)
+}
+
+// yamlComments returns the text of the lines of a YAML comment.
+func yamlComments(comment string) []string {
+	if comment == "" {
+		return nil
+	}
+	lines := strings.Split(comment, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "#"))
+	}
+	return lines
+}
+
+// convertTOML returns the TOML document in data as HuJSON, keeping the
+// position of each key and value and the comments at the top of the file.
+func convertTOML(path string, data []byte) ([]byte, error) {
+	var doc map[string]any
+	err := toml.Unmarshal(data, &doc)
+	if err != nil {
+		d := Diagnostic{Path: path, Severity: SeverityError, Rule: RuleParse, Message: err.Error()}
+		var decodeErr *toml.DecodeError
+		if errors.As(err, &decodeErr) {
+			d.Line, d.Column = decodeErr.Position()
+			d.Message = strings.TrimPrefix(err.Error(), "toml: ")
+		}
+		return nil, d
+	}
+	positions, err := readTOMLPositions(data)
+	if err != nil {
+		return nil, Diagnostic{Path: path, Severity: SeverityError, Rule: RuleParse, Message: err.Error()}
+	}
+
+	l := newLayout()
+	if line := l.tomlHeadComments(data); line > 0 {
+		l.moveTo(line+1, 1)
+	}
+	err = l.tomlValue(positions, nil, doc)
+	if err != nil {
+		return nil, Diagnostic{Path: path, Severity: SeverityError, Rule: RuleParse, Message: err.Error()}
+	}
+	return l.buf.Bytes(), nil
+}
+
+// tomlHeadComments writes the comments at the top of the TOML document in
+// data, before its first key or table, and returns the line of the last one,
+// or 0 if there are none.
+func (l *layout) tomlHeadComments(data []byte) int {
+	last := 0
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		text, ok := strings.CutPrefix(line, "#")
+		if !ok {
+			break
+		}
+		l.moveTo(i+1, 1)
+		l.write("// " + strings.TrimSpace(text))
+		l.commented = true
+		last = i + 1
+	}
+	return last
+}
+
+// tomlPositions maps the path of each key, table and value in a TOML
+// document to where it first appears. A path is the keys leading to the value
+// and the indexes of the array elements it is in, joined by tomlPath.
+type tomlPositions map[string]unstable.Position
+
+// tomlPath joins the parts of a path in tomlPositions.
+func tomlPath(parts []string) string {
+	return strings.Join(parts, "\x00")
+}
+
+// readTOMLPositions returns the positions in the TOML document in data. The
+// values are read by toml.Unmarshal, which doesn't report where they are, so
+// the document is parsed again for their positions.
+func readTOMLPositions(data []byte) (tomlPositions, error) {
+	positions := tomlPositions{}
+	// tables counts the tables of each array of tables so far, so that keys
+	// after a [[header]] are placed in its last table.
+	tables := map[string]int{}
+	var table []string
+
+	var p unstable.Parser
+	p.Reset(data)
+	for p.NextExpression() {
+		e := p.Expression()
+		switch e.Kind {
+		case unstable.KeyValue:
+			positions.keyValue(&p, table, e)
+		case unstable.Table, unstable.ArrayTable:
+			table = nil
+			for it := e.Key(); it.Next(); {
+				key := it.Node()
+				table = append(table, string(key.Data))
+				positions.add(&p, table, key)
+				if count := tables[tomlPath(table)]; count > 0 && !(it.IsLast() && e.Kind == unstable.ArrayTable) {
+					table = append(table, strconv.Itoa(count-1))
+				}
+			}
+			if e.Kind == unstable.ArrayTable {
+				path := tomlPath(table)
+				table = append(table, strconv.Itoa(tables[path]))
+				tables[path]++
+				positions.add(&p, table, e.Child())
+			}
+		}
+	}
+	return positions, p.Error()
+}
+
+// add records the position of node as that of path, unless path was seen
+// before.
+func (ps tomlPositions) add(p *unstable.Parser, path []string, node *unstable.Node) {
+	if _, ok := ps[tomlPath(path)]; !ok && node.Raw.Length > 0 {
+		ps[tomlPath(path)] = p.Shape(node.Raw).Start
+	}
+}
+
+// keyValue records the positions of the key-value e, in the table at path.
+func (ps tomlPositions) keyValue(p *unstable.Parser, path []string, e *unstable.Node) {
+	path = slices.Clone(path)
+	for it := e.Key(); it.Next(); {
+		path = append(path, string(it.Node().Data))
+		ps.add(p, path, it.Node())
+	}
+	ps.value(p, path, e.Value())
+}
+
+// value records the positions within the value v at path.
+func (ps tomlPositions) value(p *unstable.Parser, path []string, v *unstable.Node) {
+	switch v.Kind {
+	case unstable.Array:
+		i := 0
+		for it := v.Children(); it.Next(); {
+			if it.Node().Kind == unstable.Comment {
+				continue
+			}
+			item := append(slices.Clone(path), strconv.Itoa(i))
+			ps.add(p, item, it.Node())
+			ps.value(p, item, it.Node())
+			i++
+		}
+	case unstable.InlineTable:
+		for it := v.Children(); it.Next(); {
+			if it.Node().Kind == unstable.KeyValue {
+				ps.keyValue(p, path, it.Node())
+			}
+		}
+	}
+}
+
+// tomlValue writes v, read from the TOML document at path, placing it and
+// the keys of tables at their positions in the document. Keys are written
+// in the order they appear.
+func (l *layout) tomlValue(positions tomlPositions, path []string, v any) error {
+	if pos, ok := positions[tomlPath(path)]; ok && len(path) > 0 {
+		l.moveTo(pos.Line, pos.Column)
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		keys := slices.Collect(maps.Keys(t))
+		position := func(key string) unstable.Position {
+			return positions[tomlPath(append(slices.Clone(path), key))]
+		}
+		slices.SortFunc(keys, func(a, b string) int {
+			pa, pb := position(a), position(b)
+			return cmp.Or(cmp.Compare(pa.Line, pb.Line), cmp.Compare(pa.Column, pb.Column), strings.Compare(a, b))
+		})
+
+		l.write("{")
+		for _, key := range keys {
+			pos := position(key)
+			l.moveTo(pos.Line, pos.Column)
+			err := l.writeJSON(key)
+			if err != nil {
+				return err
+			}
+			l.write(":")
+			err = l.tomlValue(positions, append(slices.Clone(path), key), t[key])
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			l.write(",")
+		}
+		l.write("}")
+		return nil
+	case []any:
+		l.write("[")
+		for i, item := range t {
+			err := l.tomlValue(positions, append(slices.Clone(path), strconv.Itoa(i)), item)
+			if err != nil {
+				return err
+			}
+			l.write(",")
+		}
+		l.write("]")
+		return nil
+	case float64:
+		if math.IsInf(t, 0) || math.IsNaN(t) {
+			return fmt.Errorf("unsupported value [%v]", t)
+		}
+		return l.writeJSON(t)
+	case time.Time:
+		return l.writeJSON(t.Format(time.RFC3339Nano))
+	case toml.LocalDate, toml.LocalTime, toml.LocalDateTime:
+		return l.writeJSON(fmt.Sprint(t))
+	default:
+		return l.writeJSON(t)
+	}
+}
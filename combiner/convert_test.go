@@ -0,0 +1,194 @@
+package combiner
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+const yamlChild = `# acl-combiner: priority=10
+# owned by team a
+acls:
+  # allow team a to its servers
+  - action: accept
+    src: ["group:a"] # the whole team
+    dst:
+      - "tag:a:*"
+  - {action: accept, src: ["*"], dst: ["tag:a:443"]}
+tagOwners:
+  tag:a: [group:a]
+`
+
+const tomlChild = `# acl-combiner: priority=5
+# owned by team b
+[[acls]]
+action = "accept"
+src = ["group:b"]
+dst = ["tag:b:*"]
+
+[tagOwners]
+"tag:b" = ["group:b"]
+
+[[acls]]
+action = "accept"
+src = ["*"]
+dst = ["tag:b:443"]
+`
+
+// aclLine returns the line of the first value of member key in the acl at
+// index in doc.
+func aclLine(t *testing.T, doc *ParsedDocument, index int, key string) int {
+	t.Helper()
+	acl := doc.Object.Find("acls").Value.(*jwcc.Array).Values[index].(*jwcc.Object)
+	return jwcc.ValueLocation(acl.Find(key).Value.(*jwcc.Array).Values[0]).First.Line
+}
+
+func TestParseYAML(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"a.yaml": yamlChild})
+
+	doc, err := Parse(filepath.Join(dir, "a.yaml"))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if line := aclLine(t, doc, 0, "dst"); line != 8 {
+		t.Fatalf("dst of the first acl should be on line [8], got [%v]", line)
+	}
+	if line := aclLine(t, doc, 1, "dst"); line != 9 {
+		t.Fatalf("dst of the second acl should be on line [9], got [%v]", line)
+	}
+	owners := doc.Object.Find("tagOwners").Value.(*jwcc.Object)
+	if owners.Find("tag:a") == nil {
+		t.Fatalf("tagOwners should have [tag:a], got [%v]", owners)
+	}
+
+	acl := doc.Object.Find("acls").Value.(*jwcc.Array).Values[0]
+	comments := jwcc.CleanComments(acl.Comments().Before...)
+	if !slices.Equal(comments, []string{"allow team a to its servers"}) {
+		t.Fatalf("first acl should keep its comment, got [%v]", comments)
+	}
+	priority, err := filePriority(doc)
+	if err != nil || priority != 10 {
+		t.Fatalf("priority should be [10], got [%v] [%v]", priority, err)
+	}
+}
+
+func TestParseYAMLMergeKeys(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"a.yaml": `base: &base
+  action: accept
+  src: ["group:a"]
+acls:
+  - <<: *base
+    dst: ["tag:a:*"]
+  - <<: [*base]
+    src: ["*"]
+    dst: ["tag:a:443"]
+`,
+		"bad.yaml": "acls:\n  - <<: [1]\n",
+	})
+
+	doc, err := Parse(filepath.Join(dir, "a.yaml"))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	expected := `[{"action":"accept","src":["group:a"],"dst":["tag:a:*"]},{"action":"accept","src":["*"],"dst":["tag:a:443"]}]`
+	if acls := doc.Object.Find("acls").Value.Undecorate().JSON(); acls != expected {
+		t.Fatalf("merge keys should be expanded to [%s], got [%s]", expected, acls)
+	}
+	if line := aclLine(t, doc, 1, "dst"); line != 9 {
+		t.Fatalf("dst of the second acl should be on line [9], got [%v]", line)
+	}
+
+	_, err = Parse(filepath.Join(dir, "bad.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "line 2: merge key must refer to a mapping") {
+		t.Fatalf("expected an error for a merge key without a mapping, got [%v]", err)
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"b.toml": tomlChild})
+
+	doc, err := Parse(filepath.Join(dir, "b.toml"))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if line := aclLine(t, doc, 0, "dst"); line != 6 {
+		t.Fatalf("dst of the first acl should be on line [6], got [%v]", line)
+	}
+	if line := aclLine(t, doc, 1, "dst"); line != 14 {
+		t.Fatalf("dst of the second acl should be on line [14], got [%v]", line)
+	}
+	owners := doc.Object.Find("tagOwners").Value.(*jwcc.Object)
+	if owners.Find("tag:b") == nil {
+		t.Fatalf("tagOwners should have [tag:b], got [%v]", owners)
+	}
+
+	priority, err := filePriority(doc)
+	if err != nil || priority != 5 {
+		t.Fatalf("priority should be [5], got [%v] [%v]", priority, err)
+	}
+}
+
+func TestParseConvertedDiagnostics(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"broken.yaml": "acls:\n\t- action: accept\n",
+		"broken.toml": "[acls\naction = 1\n",
+		"list.yml":    "- acls\n",
+	})
+
+	tests := []struct {
+		name   string
+		line   int
+		column int
+	}{
+		{name: "broken.yaml", line: 2},
+		{name: "broken.toml", line: 1, column: 6},
+		{name: "list.yml", line: 1, column: 1},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		_, err := Parse(path)
+		ds := CollectDiagnostics(err)
+		if len(ds) != 1 || ds[0].Path != path || ds[0].Line != tt.line || ds[0].Column != tt.column {
+			t.Fatalf("expected a diagnostic at [%v:%d:%d], got [%v]", path, tt.line, tt.column, ds)
+		}
+	}
+}
+
+func TestCombineConvertedChildren(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"children/a.yaml":   yamlChild,
+		"children/b.toml":   tomlChild,
+		"children/c.hujson": `{"acls": [{"action": "accept", "src": ["group:c"], "dst": ["tag:c:*"]}]}`,
+	})
+
+	result, err := New(WithChildDir(filepath.Join(dir, "children")), WithAllow("acls", "tagOwners"), WithOrder(OrderPriority)).Combine(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	dsts := aclDestinations(result.Policy)
+	expected := []string{"tag:a:*", "tag:a:443", "tag:b:*", "tag:b:443", "tag:c:*"}
+	if !slices.Equal(dsts, expected) {
+		t.Fatalf("acls should be in order [%v], got [%v]", expected, dsts)
+	}
+
+	m, err := result.SourceMap()
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	expectedSource := Source{File: filepath.Join(dir, "children", "a.yaml"), Line: 9, Column: 5}
+	i := slices.IndexFunc(m.Entries, func(e SourceMapEntry) bool { return e.Pointer == "/acls/1" })
+	if i < 0 || m.Entries[i].Source != expectedSource {
+		t.Fatalf("second acl should be from [%v], got [%v]", expectedSource, m.Entries)
+	}
+
+	_, err = New(WithChildDir(filepath.Join(dir, "children")), WithAllow("acls")).Combine(context.Background())
+	ds := CollectDiagnostics(err)
+	if len(ds) != 2 || ds[0].Error() != filepath.Join(dir, "children", "a.yaml")+`:10:1: unsupported section ["tagOwners"]` {
+		t.Fatalf("expected unsupported sections in a.yaml and b.toml, got [%v]", err)
+	}
+}
@@ -0,0 +1,328 @@
+package combiner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/creachadair/jtree"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// Severity is how serious a Diagnostic is. Errors stop the policy from being
+// combined, and warnings are reported alongside the combined policy.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Rule IDs identify the kind of problem a diagnostic reports.
+const (
+	RuleRead               = "read"
+	RuleParse              = "parse"
+	RuleInvalidFormat      = "invalid-format"
+	RuleInvalidArgument    = "invalid-argument"
+	RuleInvalidConfig      = "invalid-config"
+	RuleUnsupportedSection = "unsupported-section"
+	RuleDuplicateKey       = "duplicate-key"
+	RuleNamespace          = "namespace"
+	RuleConflict           = "conflict"
+	RuleGenerated          = "generated"
+	RuleDuplicateFile      = "duplicate-file"
+)
+
+var ruleDescriptions = map[string]string{
+	RuleRead:               "File could not be read",
+	RuleParse:              "File is not valid HuJSON",
+	RuleInvalidFormat:      "File is not a policy object",
+	RuleInvalidArgument:    "Invalid command line argument",
+	RuleInvalidConfig:      "Invalid config file",
+	RuleUnsupportedSection: "Section is not allowed from this file",
+	RuleDuplicateKey:       "Key is defined more than once",
+	RuleNamespace:          "Entry is outside the namespace of this file",
+	RuleConflict:           "Section or option conflicts with another definition",
+	RuleGenerated:          "File was previously combined by tailscale-acl-combiner",
+	RuleDuplicateFile:      "File is included more than once",
+}
+
+// Diagnostic is a problem found in an input file.
+type Diagnostic struct {
+	Path string `json:"file,omitempty"`
+	// Line and Column are 1-based, or 0 when unknown.
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+	Severity Severity `json:"severity"`
+	Rule     string   `json:"rule"`
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) Error() string {
+	var sb strings.Builder
+	if d.Path != "" {
+		sb.WriteString(d.Path)
+		if d.Line > 0 {
+			fmt.Fprintf(&sb, ":%d", d.Line)
+			if d.Column > 0 {
+				fmt.Fprintf(&sb, ":%d", d.Column)
+			}
+		}
+		sb.WriteString(": ")
+	}
+	sb.WriteString(d.Message)
+	return sb.String()
+}
+
+// Diagnostics is a list of problems returned as a single error.
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) Error() string {
+	lines := make([]string, 0, len(ds))
+	for _, d := range ds {
+		lines = append(lines, d.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// err returns ds as an error, or nil if ds is empty.
+func (ds Diagnostics) err() error {
+	if len(ds) == 0 {
+		return nil
+	}
+	return ds
+}
+
+// errorAt returns an error diagnostic for v, read from path.
+func errorAt(path string, v jwcc.Value, rule string, format string, a ...any) Diagnostic {
+	loc := jwcc.ValueLocation(v)
+	d := Diagnostic{
+		Path:     path,
+		Severity: SeverityError,
+		Rule:     rule,
+		Message:  fmt.Sprintf(format, a...),
+	}
+	if loc.First.Line > 0 {
+		d.Line = loc.First.Line
+		d.Column = loc.First.Column + 1
+	}
+	return d
+}
+
+// parseError returns a diagnostic for an error from jwcc.Parse.
+func parseError(path string, err error) Diagnostic {
+	var syntaxErr *jtree.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return Diagnostic{
+			Path:     path,
+			Line:     syntaxErr.Location.Line,
+			Column:   syntaxErr.Location.Column + 1,
+			Severity: SeverityError,
+			Rule:     RuleParse,
+			Message:  syntaxErr.Message,
+		}
+	}
+	return Diagnostic{Path: path, Severity: SeverityError, Rule: RuleParse, Message: fmt.Sprintf("error parsing: %v", err)}
+}
+
+// CollectDiagnostics flattens err, which may be a Diagnostic, Diagnostics
+// or errors joined with errors.Join, into a list of diagnostics.
+func CollectDiagnostics(err error) Diagnostics {
+	if err == nil {
+		return nil
+	}
+
+	switch e := err.(type) {
+	case Diagnostics:
+		return e
+	case Diagnostic:
+		return Diagnostics{e}
+	case interface{ Unwrap() []error }:
+		var ds Diagnostics
+		for _, wrapped := range e.Unwrap() {
+			ds = append(ds, CollectDiagnostics(wrapped)...)
+		}
+		return ds
+	}
+
+	var ds Diagnostics
+	if errors.As(err, &ds) {
+		return ds
+	}
+	var d Diagnostic
+	if errors.As(err, &d) {
+		return Diagnostics{d}
+	}
+	return Diagnostics{{Severity: SeverityError, Rule: RuleInvalidArgument, Message: err.Error()}}
+}
+
+// DiagnosticsFormats are the formats WriteDiagnostics can write.
+var DiagnosticsFormats = []string{"text", "json", "sarif", "github"}
+
+// WriteDiagnostics writes ds to w in format, one of DiagnosticsFormats.
+func WriteDiagnostics(w io.Writer, format string, ds Diagnostics) error {
+	switch format {
+	case "json":
+		return printJSONDiagnostics(w, ds)
+	case "sarif":
+		return printSARIFDiagnostics(w, ds)
+	case "github":
+		printGitHubDiagnostics(w, ds)
+	default:
+		printTextDiagnostics(w, ds)
+	}
+	return nil
+}
+
+func printTextDiagnostics(w io.Writer, ds Diagnostics) {
+	errorCount := 0
+	for _, d := range ds {
+		if d.Severity == SeverityWarning {
+			fmt.Fprintf(w, "warning: %s\n", d)
+			continue
+		}
+		errorCount++
+		fmt.Fprintln(w, d)
+	}
+	if errorCount > 1 {
+		fmt.Fprintf(w, "found %d errors\n", errorCount)
+	}
+}
+
+func printJSONDiagnostics(w io.Writer, ds Diagnostics) error {
+	if ds == nil {
+		ds = Diagnostics{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ds)
+}
+
+// printGitHubDiagnostics writes ds as GitHub Actions workflow commands, which
+// annotate the file and line in the workflow run and pull request.
+//
+// https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions
+func printGitHubDiagnostics(w io.Writer, ds Diagnostics) {
+	for _, d := range ds {
+		props := []string{}
+		if d.Path != "" {
+			props = append(props, "file="+escapeGitHubProperty(filepath.ToSlash(d.Path)))
+		}
+		if d.Line > 0 {
+			props = append(props, fmt.Sprintf("line=%d", d.Line))
+		}
+		if d.Column > 0 {
+			props = append(props, fmt.Sprintf("col=%d", d.Column))
+		}
+		if d.Rule != "" {
+			props = append(props, "title="+escapeGitHubProperty(d.Rule))
+		}
+		fmt.Fprintf(w, "::%s %s::%s\n", d.Severity, strings.Join(props, ","), escapeGitHubData(d.Message))
+	}
+}
+
+func escapeGitHubData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func escapeGitHubProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// printSARIFDiagnostics writes ds as a SARIF 2.1.0 log, which GitHub code
+// scanning uses to annotate files.
+//
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+func printSARIFDiagnostics(w io.Writer, ds Diagnostics) error {
+	type sarifMessage struct {
+		Text string `json:"text"`
+	}
+	type sarifRegion struct {
+		StartLine   int `json:"startLine,omitempty"`
+		StartColumn int `json:"startColumn,omitempty"`
+	}
+	type sarifArtifactLocation struct {
+		URI string `json:"uri"`
+	}
+	type sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           *sarifRegion          `json:"region,omitempty"`
+	}
+	type sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+	type sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations,omitempty"`
+	}
+	type sarifRule struct {
+		ID               string       `json:"id"`
+		ShortDescription sarifMessage `json:"shortDescription"`
+	}
+	type sarifDriver struct {
+		Name           string      `json:"name"`
+		InformationURI string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	}
+	type sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	type sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	type sarifLog struct {
+		Schema  string     `json:"$schema"`
+		Version string     `json:"version"`
+		Runs    []sarifRun `json:"runs"`
+	}
+
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "tailscale-acl-combiner",
+			InformationURI: "https://github.com/tailscale-dev/tailscale-acl-combiner",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+
+	seenRules := map[string]bool{}
+	for _, d := range ds {
+		if !seenRules[d.Rule] {
+			seenRules[d.Rule] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+				ID:               d.Rule,
+				ShortDescription: sarifMessage{Text: ruleDescriptions[d.Rule]},
+			})
+		}
+
+		result := sarifResult{
+			RuleID:  d.Rule,
+			Level:   string(d.Severity),
+			Message: sarifMessage{Text: d.Message},
+		}
+		if d.Path != "" {
+			loc := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(d.Path)},
+			}}
+			if d.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: d.Line, StartColumn: d.Column}
+			}
+			result.Locations = []sarifLocation{loc}
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
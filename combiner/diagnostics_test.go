@@ -0,0 +1,206 @@
+package combiner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiagnosticError(t *testing.T) {
+	cases := []struct {
+		d    Diagnostic
+		want string
+	}{
+		{Diagnostic{Path: "a.hujson", Line: 3, Column: 5, Message: "oops"}, "a.hujson:3:5: oops"},
+		{Diagnostic{Path: "a.hujson", Line: 3, Message: "oops"}, "a.hujson:3: oops"},
+		{Diagnostic{Path: "a.hujson", Message: "oops"}, "a.hujson: oops"},
+		{Diagnostic{Message: "oops"}, "oops"},
+	}
+	for _, c := range cases {
+		if c.d.Error() != c.want {
+			t.Fatalf("diagnostic should be [%v], got [%v]", c.want, c.d.Error())
+		}
+	}
+}
+
+func TestCollectDiagnostics(t *testing.T) {
+	if ds := CollectDiagnostics(nil); ds != nil {
+		t.Fatalf("diagnostics should be [nil], got [%v]", ds)
+	}
+
+	err := errors.Join(
+		Diagnostics{{Path: "a", Message: "one"}, {Path: "b", Message: "two"}},
+		Diagnostic{Path: "c", Message: "three"},
+		fmt.Errorf("wrapped: %w", Diagnostic{Path: "d", Message: "four"}),
+		errors.New("five"),
+	)
+	ds := CollectDiagnostics(err)
+	if len(ds) != 5 {
+		t.Fatalf("diagnostics length should be [5], got [%v]: %v", len(ds), ds)
+	}
+	if ds[3].Path != "d" || ds[4].Message != "five" {
+		t.Fatalf("unexpected diagnostics [%v]", ds)
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "broken.hujson")
+	err := os.WriteFile(broken, []byte("{\n\tbroken file\n}"), 0o644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	_, err = Parse(broken)
+	ds := CollectDiagnostics(err)
+	if len(ds) != 1 || ds[0].Path != broken || ds[0].Line != 2 || ds[0].Column != 2 {
+		t.Fatalf("expected a diagnostic at [%v:2:2], got [%v]", broken, ds)
+	}
+
+	notObject := filepath.Join(dir, "array.hujson")
+	err = os.WriteFile(notObject, []byte("[]"), 0o644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	_, err = Parse(notObject)
+	ds = CollectDiagnostics(err)
+	if len(ds) != 1 || ds[0].Path != notObject || ds[0].Line != 1 {
+		t.Fatalf("expected a diagnostic at [%v:1], got [%v]", notObject, ds)
+	}
+}
+
+func TestMergeDocsReportsAllUnsupportedSections(t *testing.T) {
+	parentDoc, childDocs := parseTestDocs(t,
+		`{}`,
+		`{"acls": [], "derpMap": {}}`,
+		`{
+			"nodeAttrs": [],
+			"ssh": [],
+		}`,
+	)
+
+	sections := map[string]SectionHandler{"acls": ArrayHandler()}
+	err := New().mergeDocs(sections, parentDoc, childDocs)
+	ds := CollectDiagnostics(err)
+	if len(ds) != 3 {
+		t.Fatalf("diagnostics length should be [3], got [%v]: %v", len(ds), err)
+	}
+	if ds[2].Error() != `child2:3:4: unsupported section ["ssh"]` {
+		t.Fatalf("unexpected diagnostic [%v]", ds[2])
+	}
+}
+
+func TestPrintDiagnostics(t *testing.T) {
+	ds := Diagnostics{
+		{Path: "a", Line: 1, Severity: SeverityError, Rule: RuleParse, Message: "one"},
+		{Path: "b", Severity: SeverityWarning, Rule: RuleDuplicateKey, Message: "two"},
+		{Path: "c", Line: 2, Column: 3, Severity: SeverityError, Rule: RuleNamespace, Message: "three, 100%\nfour"},
+	}
+
+	cases := map[string]string{
+		"text": "a:1: one\nwarning: b: two\nc:2:3: three, 100%\nfour\nfound 2 errors\n",
+		"github": "::error file=a,line=1,title=parse::one\n" +
+			"::warning file=b,title=duplicate-key::two\n" +
+			"::error file=c,line=2,col=3,title=namespace::three, 100%25%0Afour\n",
+	}
+	for format, expected := range cases {
+		var buf bytes.Buffer
+		err := WriteDiagnostics(&buf, format, ds)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		if buf.String() != expected {
+			t.Fatalf("[%v] output should be [%v], got [%v]", format, expected, buf.String())
+		}
+	}
+}
+
+func TestPrintJSONDiagnostics(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteDiagnostics(&buf, "json", Diagnostics{{Path: "a", Line: 1, Column: 2, Severity: SeverityError, Rule: RuleParse, Message: "one"}})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	var decoded []map[string]any
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if len(decoded) != 1 || decoded[0]["file"] != "a" || decoded[0]["line"] != float64(1) || decoded[0]["rule"] != RuleParse {
+		t.Fatalf("unexpected json [%v]", buf.String())
+	}
+
+	buf.Reset()
+	err = WriteDiagnostics(&buf, "json", nil)
+	if err != nil || buf.String() != "[]\n" {
+		t.Fatalf("output should be [[]], got [%v] [%v]", buf.String(), err)
+	}
+}
+
+func TestPrintSARIFDiagnostics(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteDiagnostics(&buf, "sarif", Diagnostics{
+		{Path: "dir/a.hujson", Line: 1, Column: 2, Severity: SeverityError, Rule: RuleParse, Message: "one"},
+		{Path: "b.hujson", Severity: SeverityWarning, Rule: RuleDuplicateKey, Message: "two"},
+		{Severity: SeverityError, Rule: RuleParse, Message: "three"},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	var decoded struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Rules []struct {
+						ID string `json:"id"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+						Region *struct {
+							StartLine   int `json:"startLine"`
+							StartColumn int `json:"startColumn"`
+						} `json:"region"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if decoded.Version != "2.1.0" || len(decoded.Runs) != 1 {
+		t.Fatalf("unexpected sarif [%v]", buf.String())
+	}
+	run := decoded.Runs[0]
+	if len(run.Tool.Driver.Rules) != 2 || len(run.Results) != 3 {
+		t.Fatalf("unexpected sarif [%v]", buf.String())
+	}
+	first := run.Results[0]
+	if first.Level != "error" || first.Locations[0].PhysicalLocation.ArtifactLocation.URI != "dir/a.hujson" || first.Locations[0].PhysicalLocation.Region.StartColumn != 2 {
+		t.Fatalf("unexpected sarif result [%+v]", first)
+	}
+	if run.Results[1].Level != "warning" || run.Results[1].Locations[0].PhysicalLocation.Region != nil {
+		t.Fatalf("unexpected sarif result [%+v]", run.Results[1])
+	}
+	if len(run.Results[2].Locations) != 0 {
+		t.Fatalf("unexpected sarif result [%+v]", run.Results[2])
+	}
+}
@@ -0,0 +1,190 @@
+package combiner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// DuplicatePolicy decides what happens when a key in an object section is
+// defined more than once across the parent and children.
+type DuplicatePolicy string
+
+const (
+	// DuplicateError fails the merge when a key is defined more than once.
+	DuplicateError DuplicatePolicy = "error"
+	// DuplicateWarn logs the duplicate and keeps every definition. Sections
+	// merged by GroupsHandler, such as groups, still combine them.
+	DuplicateWarn DuplicatePolicy = "warn"
+	// DuplicateUnion combines the array values of every definition.
+	DuplicateUnion DuplicatePolicy = "union"
+	// DuplicateParentWins keeps the first definition, from the parent file if
+	// it has one, and drops the rest.
+	DuplicateParentWins DuplicatePolicy = "parent-wins"
+)
+
+// objectSections are the object-typed sections checked for duplicate keys.
+// Nested sections are separated by a dot.
+var objectSections = []string{
+	"autoApprovers.routes",
+	"groups",
+	"hosts",
+	"ipsets",
+	"postures",
+	"tagOwners",
+}
+
+// DuplicatePolicies maps a section in objectSections to the policy used when
+// a key in that section is defined more than once. Sections without an entry
+// use DuplicateError.
+type DuplicatePolicies map[string]DuplicatePolicy
+
+// DefaultDuplicatePolicies returns the policies used when none are provided,
+// which combine the members of groups with the same name.
+func DefaultDuplicatePolicies() DuplicatePolicies {
+	return DuplicatePolicies{"groups": DuplicateUnion}
+}
+
+func (p DuplicatePolicies) String() string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	values := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values = append(values, fmt.Sprintf("%s=%s", k, p[k]))
+	}
+	return strings.Join(values, ",")
+}
+
+func (p DuplicatePolicies) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		section, policy, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("invalid duplicate policy [%s], expected [section=policy]", v)
+		}
+		canonical, ok := objectSection(section)
+		if !ok {
+			return fmt.Errorf("unsupported section [%s] for duplicate policy, expected one of %v", section, objectSections)
+		}
+		section = canonical
+		switch DuplicatePolicy(policy) {
+		case DuplicateError, DuplicateWarn, DuplicateUnion, DuplicateParentWins:
+			p[section] = DuplicatePolicy(policy)
+		default:
+			return fmt.Errorf("unsupported duplicate policy [%s] for section [%s]", policy, section)
+		}
+	}
+	return nil
+}
+
+func (p DuplicatePolicies) get(section string) DuplicatePolicy {
+	if policy, ok := p[section]; ok {
+		return policy
+	}
+	return DuplicateError
+}
+
+// objectSection returns the section in objectSections matching section
+// case-insensitively.
+func objectSection(section string) (string, bool) {
+	for _, s := range objectSections {
+		if strings.EqualFold(s, section) {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+type keyDefinition struct {
+	path   string
+	line   int
+	member *jwcc.Member
+}
+
+// resolveDuplicates checks every section in objectSections of the parent and
+// the children for keys that are defined more than once, and applies the
+// section's policy to each collision. All collisions that fail under the
+// DuplicateError policy are returned together as Diagnostics.
+func (c *Combiner) resolveDuplicates(sections map[string]SectionHandler, parentDoc *ParsedDocument, childDocs []*ParsedDocument) error {
+	var diags Diagnostics
+	for _, section := range objectSections {
+		docs := []*ParsedDocument{parentDoc}
+		topLevelKey, _, _ := strings.Cut(section, ".")
+		for _, child := range childDocs {
+			if child.allowedSections(sections)[topLevelKey] != nil {
+				docs = append(docs, child)
+			}
+		}
+
+		seen := map[string]keyDefinition{}
+		for i, doc := range docs {
+			if i != 0 && doc.Path == parentDoc.Path {
+				continue
+			}
+
+			obj := findObjectSection(doc.Object, section)
+			if obj == nil {
+				continue
+			}
+
+			kept := make([]*jwcc.Member, 0, len(obj.Members))
+			for _, m := range obj.Members {
+				key := m.Key.String()
+				line := jwcc.ValueLocation(m).First.Line
+				first, ok := seen[key]
+				if !ok {
+					seen[key] = keyDefinition{path: doc.Path, line: line, member: m}
+					kept = append(kept, m)
+					continue
+				}
+
+				dup := errorAt(doc.Path, m, RuleDuplicateKey, "duplicate key [\"%s\"] in section [%s], first defined at [%s:%d]", key, section, first.path, first.line)
+				switch c.duplicates.get(section) {
+				case DuplicateWarn:
+					dup.Severity = SeverityWarning
+					c.warn(dup)
+					kept = append(kept, m)
+				case DuplicateUnion:
+					firstArr, firstOk := first.member.Value.(*jwcc.Array)
+					arr, ok := m.Value.(*jwcc.Array)
+					if !firstOk || !ok {
+						dup.Message += ": only arrays can be combined"
+						diags = append(diags, dup)
+						continue
+					}
+					c.logf("combining %s\n", dup)
+					unionArray(firstArr, arr, doc.Path)
+				case DuplicateParentWins:
+					c.logf("ignoring %s\n", dup)
+				default:
+					diags = append(diags, dup)
+				}
+			}
+			obj.Members = kept
+		}
+	}
+	return diags.err()
+}
+
+// findObjectSection returns the object at the dot-separated section path in
+// doc, or nil if it does not exist or is not an object.
+func findObjectSection(doc *jwcc.Object, section string) *jwcc.Object {
+	obj := doc
+	for _, key := range strings.Split(section, ".") {
+		m := obj.Find(key)
+		if m == nil {
+			return nil
+		}
+		next, ok := m.Value.(*jwcc.Object)
+		if !ok {
+			return nil
+		}
+		obj = next
+	}
+	return obj
+}
@@ -0,0 +1,181 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func parseTestDocs(t *testing.T, parentSrc string, childSrcs ...string) (*ParsedDocument, []*ParsedDocument) {
+	t.Helper()
+
+	parent, err := jwcc.Parse(strings.NewReader(parentSrc))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentDoc := &ParsedDocument{
+		Object: parent.Value.(*jwcc.Object),
+		Path:   "parent",
+	}
+
+	childDocs := []*ParsedDocument{}
+	for i, src := range childSrcs {
+		child, err := jwcc.Parse(strings.NewReader(src))
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		childDocs = append(childDocs, &ParsedDocument{
+			Object: child.Value.(*jwcc.Object),
+			Path:   "child" + string(rune('1'+i)),
+		})
+	}
+	return parentDoc, childDocs
+}
+
+func TestResolveDuplicatesError(t *testing.T) {
+	parentDoc, childDocs := parseTestDocs(t,
+		`{
+			"tagOwners": {"tag:a": []},
+		}`,
+		`{
+			"tagOwners": {"tag:a": []},
+			"hosts": {"host1": "100.64.0.1"},
+		}`,
+		`{
+			"hosts": {
+				"host1": "100.64.0.2",
+			},
+		}`,
+	)
+
+	err := New(WithDuplicates(DuplicatePolicies{})).resolveDuplicates(DefaultSections(), parentDoc, childDocs)
+	if err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+
+	expected := []string{
+		`child2:3:5: duplicate key ["host1"] in section [hosts], first defined at [child1:3]`,
+		`child1:2:18: duplicate key ["tag:a"] in section [tagOwners], first defined at [parent:2]`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(err.Error(), e) {
+			t.Fatalf("error should contain [%v], got [%v]", e, err)
+		}
+	}
+}
+
+func TestResolveDuplicatesNotAllowedInChildren(t *testing.T) {
+	parentDoc, childDocs := parseTestDocs(t,
+		`{"tagOwners": {"tag:a": []}}`,
+		`{"tagOwners": {"tag:a": []}}`,
+	)
+
+	sections := map[string]SectionHandler{"acls": ArrayHandler()}
+	err := New(WithDuplicates(DuplicatePolicies{})).resolveDuplicates(sections, parentDoc, childDocs)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+}
+
+func TestResolveDuplicatesWarn(t *testing.T) {
+	parentDoc, childDocs := parseTestDocs(t,
+		`{"ipsets": {"ipset:a": ["192.0.2.0"]}}`,
+		`{"ipsets": {"ipset:a": ["192.0.2.1"]}}`,
+	)
+
+	c := New(WithDuplicates(DuplicatePolicies{"ipsets": DuplicateWarn}))
+	err := c.resolveDuplicates(DefaultSections(), parentDoc, childDocs)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	warnings := c.warnings
+	if len(warnings) != 1 || warnings[0].Severity != SeverityWarning {
+		t.Fatalf("expected a single warning, got [%v]", warnings)
+	}
+
+	childMembers := childDocs[0].Object.Find("ipsets").Value.(*jwcc.Object).Members
+	if len(childMembers) != 1 {
+		t.Fatalf("child members length should be [1], got [%v]", len(childMembers))
+	}
+}
+
+func TestResolveDuplicatesUnion(t *testing.T) {
+	parentDoc, childDocs := parseTestDocs(t,
+		`{"autoApprovers": {"routes": {"10.0.0.0/24": ["tag:a"]}}}`,
+		`{"autoApprovers": {"routes": {"10.0.0.0/24": ["tag:a", "tag:b"]}}}`,
+	)
+
+	err := mergeDocsWithPolicies(t, DuplicatePolicies{"autoApprovers.routes": DuplicateUnion}, parentDoc, childDocs)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	routes := findObjectSection(parentDoc.Object, "autoApprovers.routes").Members
+	if len(routes) != 1 {
+		t.Fatalf("routes length should be [1], got [%v]", len(routes))
+	}
+	approvers := routes[0].Value.(*jwcc.Array).Values
+	if len(approvers) != 2 {
+		t.Fatalf("approvers length should be [2], got [%v]", len(approvers))
+	}
+}
+
+func TestResolveDuplicatesUnionNonArray(t *testing.T) {
+	parentDoc, childDocs := parseTestDocs(t,
+		`{"hosts": {"host1": "100.64.0.1"}}`,
+		`{"hosts": {"host1": "100.64.0.2"}}`,
+	)
+
+	err := New(WithDuplicates(DuplicatePolicies{"hosts": DuplicateUnion})).resolveDuplicates(DefaultSections(), parentDoc, childDocs)
+	if err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+}
+
+func TestResolveDuplicatesParentWins(t *testing.T) {
+	parentDoc, childDocs := parseTestDocs(t,
+		`{"hosts": {"host1": "100.64.0.1"}}`,
+		`{"hosts": {"host1": "100.64.0.2", "host2": "100.64.0.3"}}`,
+	)
+
+	err := mergeDocsWithPolicies(t, DuplicatePolicies{"hosts": DuplicateParentWins}, parentDoc, childDocs)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	hosts := parentDoc.Object.Find("hosts").Value.(*jwcc.Object)
+	if len(hosts.Members) != 2 {
+		t.Fatalf("hosts length should be [2], got [%v]", len(hosts.Members))
+	}
+	if hosts.Find("host1").Value.String() != "100.64.0.1" {
+		t.Fatalf("host value should be [100.64.0.1], got [%v]", hosts.Find("host1").Value.String())
+	}
+}
+
+func TestDuplicatePoliciesSet(t *testing.T) {
+	policies := DuplicatePolicies{}
+	err := policies.Set("hosts=warn,autoapprovers.Routes=union")
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if policies.get("hosts") != DuplicateWarn {
+		t.Fatalf("policy should be [%v], got [%v]", DuplicateWarn, policies.get("hosts"))
+	}
+	if policies.get("ipsets") != DuplicateError {
+		t.Fatalf("policy should be [%v], got [%v]", DuplicateError, policies.get("ipsets"))
+	}
+
+	for _, invalid := range []string{"hosts", "acls=warn", "hosts=invalid"} {
+		if err := policies.Set(invalid); err == nil {
+			t.Fatalf("expected error for [%v], got [%v]", invalid, err)
+		}
+	}
+}
+
+func mergeDocsWithPolicies(t *testing.T, policies DuplicatePolicies, parentDoc *ParsedDocument, childDocs []*ParsedDocument) error {
+	t.Helper()
+
+	return New(WithDuplicates(policies)).mergeDocs(DefaultSections(), parentDoc, childDocs)
+}
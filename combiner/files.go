@@ -0,0 +1,224 @@
+package combiner
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ChildExtensions are the extensions of the files merged as children. YAML
+// and TOML files are converted to HuJSON by Parse.
+var ChildExtensions = []string{".json", ".hujson", ".yaml", ".yml", ".toml"}
+
+// ChildFile is a file considered for merging as a child.
+type ChildFile struct {
+	Path string
+	// Merge reports whether the file is merged.
+	Merge bool
+	// Reason explains why the file is or isn't merged.
+	Reason string
+
+	// source is the child directory or file list the file was found in, and
+	// duplicate is set if it was found before.
+	source    string
+	duplicate bool
+
+	// sourceIndex, key and rank order the file with sortFiles.
+	sourceIndex int
+	key         []string
+	rank        int
+}
+
+// Files returns the files considered for merging as children, from each
+// child directory and WithChildFiles, in the order from WithOrder they are
+// merged in. Directories ignored by an IgnoreFile are returned in place of
+// the files beneath them.
+func (c *Combiner) Files(ctx context.Context) ([]ChildFile, error) {
+	for _, glob := range c.include {
+		err := checkGlob(glob)
+		if err != nil {
+			return nil, Diagnostic{Severity: SeverityError, Rule: RuleInvalidArgument, Message: fmt.Sprintf("invalid include glob [%s]: %v", glob, err)}
+		}
+	}
+	for _, glob := range c.exclude {
+		err := checkGlob(glob)
+		if err != nil {
+			return nil, Diagnostic{Severity: SeverityError, Rule: RuleInvalidArgument, Message: fmt.Sprintf("invalid exclude glob [%s]: %v", glob, err)}
+		}
+	}
+
+	files := []ChildFile{}
+	for i, dir := range c.childDirs {
+		found, err := c.walkChildDir(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		for j := range found {
+			found[j].sourceIndex = i
+		}
+		files = append(files, found...)
+	}
+	for _, path := range c.childFileList {
+		f := c.childFile(path, filepath.ToSlash(path), "in the file list")
+		f.sourceIndex = len(c.childDirs)
+		files = append(files, f)
+	}
+	err := c.sortFiles(files)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]ChildFile{}
+	for i, f := range files {
+		if !f.Merge {
+			continue
+		}
+		path := absPath(f.Path)
+		first, ok := seen[path]
+		if !ok {
+			seen[path] = f
+			continue
+		}
+		reason := fmt.Sprintf("included more than once, %s and %s", first.source, f.source)
+		if first.source == f.source {
+			reason = fmt.Sprintf("included more than once %s", f.source)
+		}
+		files[i] = ChildFile{Path: f.Path, Reason: reason, source: f.source, duplicate: true, sourceIndex: f.sourceIndex, key: f.key, rank: f.rank}
+	}
+	return files, nil
+}
+
+// walkChildDir returns the files considered for merging under dir.
+func (c *Combiner) walkChildDir(ctx context.Context, dir string) ([]ChildFile, error) {
+	source := fmt.Sprintf("under [%s]", dir)
+	files := []ChildFile{}
+	// ignores maps directories to the ignore files that apply to them, from
+	// the top down.
+	ignores := map[string][]*ignoreFile{}
+
+	c.logf("walking path [%v]...\n", dir)
+	err := filepath.WalkDir(
+		dir,
+		func(path string, info fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+
+			if info.IsDir() {
+				var inherited []*ignoreFile
+				if rel != "." {
+					inherited = ignores[filepath.Dir(path)]
+					if reason, ok := ignored(inherited, rel, true); ok {
+						files = append(files, ChildFile{Path: path, Reason: reason, source: source, key: c.sortKey(rel)})
+						return filepath.SkipDir
+					}
+				}
+
+				ignore, err := parseIgnoreFile(filepath.Join(path, IgnoreFile), rel)
+				if err != nil {
+					return err
+				}
+				if ignore != nil {
+					inherited = append(slices.Clip(inherited), ignore)
+				}
+				ignores[filepath.Clean(path)] = inherited
+
+				parentFile := filepath.Join(path, c.parentFileName)
+				if _, err := os.Stat(parentFile); err == nil {
+					files = append(files, c.walkedFile(inherited, parentFile, filepath.ToSlash(filepath.Join(rel, c.parentFileName)), source))
+				}
+				return nil
+			}
+
+			if info.Name() == c.parentFileName || info.Name() == IgnoreFile {
+				return nil
+			}
+			if info.Type()&fs.ModeSymlink != 0 {
+				if target, err := os.Stat(path); err == nil && target.IsDir() {
+					files = append(files, ChildFile{Path: path, Reason: "symlink to a directory, not followed", source: source, key: c.sortKey(rel)})
+					return nil
+				}
+			}
+			files = append(files, c.walkedFile(ignores[filepath.Dir(path)], path, rel, source))
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// walkedFile returns whether the file at path, found under a child directory
+// at rel, is merged given the ignore files that apply to it.
+func (c *Combiner) walkedFile(ignores []*ignoreFile, path string, rel string, source string) ChildFile {
+	if reason, ok := ignored(ignores, rel, false); ok {
+		return ChildFile{Path: path, Reason: reason, source: source, key: c.sortKey(rel)}
+	}
+	return c.childFile(path, rel, source)
+}
+
+// childFile returns whether the file at path is merged, matching rel against
+// the globs from WithInclude and WithExclude.
+func (c *Combiner) childFile(path string, rel string, source string) ChildFile {
+	key := c.sortKey(rel)
+	skip := func(reason string) ChildFile {
+		return ChildFile{Path: path, Reason: reason, source: source, key: key}
+	}
+	if !slices.Contains(ChildExtensions, filepath.Ext(path)) {
+		return skip(fmt.Sprintf("extension is not one of %v", ChildExtensions))
+	}
+	if filepath.Base(path) == DefaultConfigFile || path == c.configPath {
+		return skip("config file")
+	}
+	for _, glob := range c.exclude {
+		if matchGlob(glob, rel) {
+			return skip(fmt.Sprintf("excluded by [%s]", glob))
+		}
+	}
+
+	reason := source
+	if len(c.include) > 0 {
+		i := slices.IndexFunc(c.include, func(glob string) bool { return matchGlob(glob, rel) })
+		if i < 0 {
+			return skip("not matched by any include glob")
+		}
+		reason = fmt.Sprintf("%s, included by [%s]", source, c.include[i])
+	}
+	return ChildFile{Path: path, Merge: true, Reason: reason, source: source, key: key}
+}
+
+// checkGlob returns an error if a segment of glob is not a valid pattern for
+// path.Match.
+func checkGlob(glob string) error {
+	for _, segment := range strings.Split(glob, "/") {
+		_, err := path.Match(segment, "")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// absPath returns the absolute form of path, for comparing paths given
+// relative to different directories, or the cleaned path if there is none.
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return abs
+}
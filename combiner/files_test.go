@@ -0,0 +1,59 @@
+package combiner
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestFiles(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"children/" + IgnoreFile:              "drafts/\n",
+		"children/" + DefaultConfigFile:       `{}`,
+		"children/eng/acls.hujson":            `{}`,
+		"children/eng/acls.example.hujson":    `{}`,
+		"children/eng/README.md":              ``,
+		"children/eng/drafts/acls.hujson":     `{}`,
+		"children/finance/_parent.hujson":     `{}`,
+		"children/finance/acls.hujson":        `{}`,
+		"children/finance/fixtures/acls.json": `{}`,
+	})
+	children := filepath.Join(dir, "children")
+
+	files, err := New(
+		WithChildDir(children),
+		WithInclude("eng/**", "finance/*"),
+		WithExclude("**/*.example.hujson"),
+	).Files(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	source := "under [" + children + "]"
+	expected := []ChildFile{
+		{Path: filepath.Join(children, DefaultConfigFile), Reason: "config file"},
+		{Path: filepath.Join(children, "eng", "README.md"), Reason: "extension is not one of [.json .hujson .yaml .yml .toml]"},
+		{Path: filepath.Join(children, "eng", "acls.example.hujson"), Reason: "excluded by [**/*.example.hujson]"},
+		{Path: filepath.Join(children, "eng", "acls.hujson"), Merge: true, Reason: source + ", included by [eng/**]"},
+		{Path: filepath.Join(children, "eng", "drafts"), Reason: "ignored by [drafts/] in [" + filepath.Join(children, IgnoreFile) + ":1]"},
+		{Path: filepath.Join(children, "finance", DefaultParentFile), Merge: true, Reason: source + ", included by [finance/*]"},
+		{Path: filepath.Join(children, "finance", "acls.hujson"), Merge: true, Reason: source + ", included by [finance/*]"},
+		{Path: filepath.Join(children, "finance", "fixtures", "acls.json"), Reason: "not matched by any include glob"},
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("files length should be [%d], got [%v]", len(expected), files)
+	}
+	for i, f := range files {
+		if f.Path != expected[i].Path || f.Merge != expected[i].Merge || f.Reason != expected[i].Reason {
+			t.Fatalf("file [%d] should be [%+v], got [%+v]", i, expected[i], f)
+		}
+	}
+}
+
+func TestFilesInvalidGlob(t *testing.T) {
+	_, err := New(WithExclude("[a")).Files(context.Background())
+	expected := "invalid exclude glob [[a]: syntax error in pattern"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error [%v], got [%v]", expected, err)
+	}
+}
@@ -0,0 +1,161 @@
+package combiner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// generatedMarker starts the comment added to the top of combined policies,
+// following the convention for generated Go code.
+const generatedMarker = "Code generated by tailscale-acl-combiner"
+
+// markGenerated adds a comment to the top of doc recording that it was
+// combined from the parent file at parentPath, merging children in order.
+func markGenerated(doc *jwcc.Object, parentPath string, order MergeOrder) {
+	unmarkGenerated(doc)
+	marker := fmt.Sprintf("%s, children merged in %s order. DO NOT EDIT.", generatedMarker, order)
+	if parentPath != "" {
+		marker = fmt.Sprintf("%s from `%s`, children merged in %s order. DO NOT EDIT.", generatedMarker, parentPath, order)
+	}
+	com := doc.Comments()
+	com.Before = append([]string{marker}, com.Before...)
+}
+
+// unmarkGenerated removes the comment added by markGenerated from doc.
+func unmarkGenerated(doc *jwcc.Object) {
+	com := doc.Comments()
+	var before []string
+	for _, c := range com.Before {
+		if _, ok := generatedComment(c); !ok {
+			before = append(before, c)
+		}
+	}
+	com.Before = before
+}
+
+// generatedFrom returns the parent file doc was combined from, or "" if it
+// was combined without one, if doc has the comment added by markGenerated.
+func generatedFrom(doc *jwcc.Object) (string, bool) {
+	for _, c := range doc.Comments().Before {
+		if parentPath, ok := generatedComment(c); ok {
+			return parentPath, true
+		}
+	}
+	return "", false
+}
+
+func generatedComment(c string) (string, bool) {
+	lines := jwcc.CleanComments(c)
+	if len(lines) != 1 {
+		return "", false
+	}
+	rest, ok := strings.CutPrefix(lines[0], generatedMarker)
+	if !ok {
+		return "", false
+	}
+	rest, ok = strings.CutPrefix(rest, " from `")
+	if !ok {
+		return "", true
+	}
+	parentPath, _, _ := strings.Cut(rest, "`")
+	return parentPath, true
+}
+
+// stripGenerated removes the entries merged from children when parentDoc is
+// a policy previously combined by this tool, keeping only the entries from
+// the parent it was combined from, so that children are not merged twice.
+// parentDoc.Path is then set to the path of that parent.
+func (c *Combiner) stripGenerated(parentDoc *ParsedDocument) error {
+	originalPath, ok := generatedFrom(parentDoc.Object)
+	if !ok {
+		return nil
+	}
+
+	marked := false
+	removed := 0
+	var strip func(v jwcc.Value, file string) bool
+	keep := func(v jwcc.Value, file string) (string, bool) {
+		if path, ok := Provenance(v); ok {
+			file = path
+			marked = true
+		}
+		if file != originalPath {
+			removed++
+			return file, false
+		}
+		return file, !strip(v, file)
+	}
+	// strip removes the entries of v that came from other files, and reports
+	// whether v was left empty by removing them.
+	strip = func(v jwcc.Value, file string) bool {
+		switch t := v.(type) {
+		case *jwcc.Member:
+			return strip(t.Value, file)
+		case *jwcc.Array:
+			if len(t.Values) == 0 {
+				return false
+			}
+			values := []jwcc.Value{}
+			for _, v := range t.Values {
+				var ok bool
+				if file, ok = keep(v, file); ok {
+					values = append(values, v)
+				}
+			}
+			t.Values = values
+			return len(values) == 0
+		case *jwcc.Object:
+			if len(t.Members) == 0 {
+				return false
+			}
+			members := []*jwcc.Member{}
+			for _, m := range t.Members {
+				var ok bool
+				if file, ok = keep(m, file); ok {
+					members = append(members, m)
+				}
+			}
+			t.Members = members
+			return len(members) == 0
+		}
+		return false
+	}
+
+	sections := []*jwcc.Member{}
+	for _, section := range parentDoc.Object.Members {
+		if _, ok := keep(section, originalPath); ok {
+			sections = append(sections, section)
+		}
+	}
+
+	if !marked && len(parentDoc.Object.Members) > 0 {
+		return Diagnostic{Path: parentDoc.Path, Severity: SeverityError, Rule: RuleGenerated, Message: fmt.Sprintf("[%s] was combined by tailscale-acl-combiner without provenance comments, so the entries merged from children can't be removed - combine from [%s] instead", parentDoc.Path, originalPath)}
+	}
+
+	parentDoc.Object.Members = sections
+	unmarkGenerated(parentDoc.Object)
+	c.warn(Diagnostic{Path: parentDoc.Path, Severity: SeverityWarning, Rule: RuleGenerated, Message: fmt.Sprintf("[%s] was combined by tailscale-acl-combiner from [%s], removed %d entries merged from other files", parentDoc.Path, originalPath, removed)})
+	// The remaining entries are from the original parent, so they are
+	// recorded as coming from it, and combining again produces the same
+	// policy.
+	parentDoc.Path = originalPath
+	return nil
+}
+
+// rejectGenerated returns the children that were not combined by this tool,
+// and an error for each one that was, since its entries would be merged
+// twice.
+func rejectGenerated(childDocs []*ParsedDocument) ([]*ParsedDocument, error) {
+	var diags Diagnostics
+	children := []*ParsedDocument{}
+	for _, child := range childDocs {
+		if _, ok := generatedFrom(child.Object); ok {
+			diags = append(diags, Diagnostic{Path: child.Path, Severity: SeverityError, Rule: RuleGenerated, Message: fmt.Sprintf("[%s] was combined by tailscale-acl-combiner and can't be merged as a child - move it out of the child directory", child.Path)})
+			continue
+		}
+		children = append(children, child)
+	}
+	return children, diags.err()
+}
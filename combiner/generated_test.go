@@ -0,0 +1,144 @@
+package combiner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func TestGeneratedComment(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`// header
+	{}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	obj := doc.Value.(*jwcc.Object)
+	if _, ok := generatedFrom(obj); ok {
+		t.Fatalf("policy should not be generated, got [%v]", obj.Comments().Before)
+	}
+
+	markGenerated(obj, "parent.hujson", OrderLexical)
+	markGenerated(obj, "other.hujson", OrderLexical)
+	if len(obj.Comments().Before) != 2 {
+		t.Fatalf("comments length should be [2], got [%v]", obj.Comments().Before)
+	}
+	parentPath, ok := generatedFrom(obj)
+	if !ok || parentPath != "other.hujson" {
+		t.Fatalf("policy should be generated from [other.hujson], got [%v]", parentPath)
+	}
+
+	markGenerated(obj, "", OrderLexical)
+	parentPath, ok = generatedFrom(obj)
+	if !ok || parentPath != "" {
+		t.Fatalf("policy should be generated without a parent, got [%v]", parentPath)
+	}
+
+	unmarkGenerated(obj)
+	if _, ok := generatedFrom(obj); ok || len(obj.Comments().Before) != 1 {
+		t.Fatalf("generated comment should be removed, got [%v]", obj.Comments().Before)
+	}
+}
+
+func TestCombineGeneratedParent(t *testing.T) {
+	for _, style := range []ProvenanceStyle{ProvenanceFirst, ProvenanceEvery, ProvenanceTrailing} {
+		t.Run(string(style), func(t *testing.T) {
+			dir := writeTestFiles(t, map[string]string{
+				"parent.hujson": `{
+					"acls": [{"action": "accept", "src": ["*"], "dst": ["*:22"]}],
+					"groups": {"group:eng": ["a@example.com"]},
+				}`,
+				"children/eng.hujson": `{
+					"acls": [
+						{"action": "accept", "src": ["group:eng"], "dst": ["tag:eng:*"]},
+						{"action": "accept", "src": ["group:eng"], "dst": ["tag:db:*"]},
+					],
+					"groups": {"group:eng": ["b@example.com"], "group:db": ["c@example.com"]},
+					"tagOwners": {"tag:eng": ["group:eng"]},
+				}`,
+			})
+			combine := func(parentPath string) *Result {
+				result, err := New(
+					WithParent(parentPath),
+					WithChildDir(filepath.Join(dir, "children")),
+					WithAllow("acls", "groups", "tagOwners"),
+					WithProvenance(style),
+				).Combine(context.Background())
+				if err != nil {
+					t.Fatalf("expected no error, got [%v]", err)
+				}
+				return result
+			}
+
+			first := combine(filepath.Join(dir, "parent.hujson"))
+			if len(first.Warnings) != 0 {
+				t.Fatalf("expected no warnings, got [%v]", first.Warnings)
+			}
+			formatted, err := Format(first.Policy)
+			if err != nil {
+				t.Fatalf("expected no error, got [%v]", err)
+			}
+			generatedPath := filepath.Join(dir, "generated.hujson")
+			err = os.WriteFile(generatedPath, formatted, 0644)
+			if err != nil {
+				t.Fatalf("expected no error, got [%v]", err)
+			}
+
+			second := combine(generatedPath)
+			expected := "[" + generatedPath + "] was combined by tailscale-acl-combiner from [" + filepath.Join(dir, "parent.hujson") + "], removed 5 entries merged from other files"
+			if len(second.Warnings) != 1 || second.Warnings[0].Message != expected || second.Warnings[0].Rule != RuleGenerated {
+				t.Fatalf("expected warning [%v], got [%v]", expected, second.Warnings)
+			}
+
+			// Combining the combined policy again is a fixed point, down to
+			// the provenance comments and the header naming the parent.
+			reformatted, err := Format(second.Policy)
+			if err != nil {
+				t.Fatalf("expected no error, got [%v]", err)
+			}
+			if string(reformatted) != string(formatted) {
+				t.Fatalf("combining again should produce [%s], got [%s]", formatted, reformatted)
+			}
+		})
+	}
+}
+
+func TestCombineGeneratedParentWithoutProvenance(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"generated.hujson": "// " + generatedMarker + " from `parent.hujson`. DO NOT EDIT.\n" + `{
+			"acls": [{"action": "accept", "src": ["*"], "dst": ["*:22"]}],
+		}`,
+		"children/.keep.hujson": `{}`,
+	})
+
+	_, err := New(
+		WithParent(filepath.Join(dir, "generated.hujson")),
+		WithChildDir(filepath.Join(dir, "children")),
+		WithAllow("acls"),
+	).Combine(context.Background())
+	expected := "was combined by tailscale-acl-combiner without provenance comments, so the entries merged from children can't be removed - combine from [parent.hujson] instead"
+	if err == nil || !strings.HasSuffix(err.Error(), expected) {
+		t.Fatalf("expected error [%v], got [%v]", expected, err)
+	}
+}
+
+func TestCombineGeneratedChild(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"parent.hujson":             `{}`,
+		"children/acls.hujson":      `{"acls": [{"action": "accept", "src": ["*"], "dst": ["*:22"]}]}`,
+		"children/generated.hujson": "// " + generatedMarker + " from `parent.hujson`. DO NOT EDIT.\n" + `{"acls": []}`,
+	})
+
+	_, err := New(
+		WithParent(filepath.Join(dir, "parent.hujson")),
+		WithChildDir(filepath.Join(dir, "children")),
+		WithAllow("acls"),
+	).Combine(context.Background())
+	ds := CollectDiagnostics(err)
+	if len(ds) != 1 || ds[0].Rule != RuleGenerated || ds[0].Path != filepath.Join(dir, "children", "generated.hujson") {
+		t.Fatalf("expected a generated error for the child, got [%v]", ds)
+	}
+}
@@ -0,0 +1,186 @@
+package combiner
+
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// DefaultParentFile is the name of the files that define sections for the
+// directory containing them, like a child, and may narrow the sections
+// allowed from the files beneath that directory.
+const DefaultParentFile = "_parent.hujson"
+
+// delegation is the part of a parent file such as:
+//
+//	{
+//		"allow": ["acls", "tagOwners"],
+//		"groups": {
+//			"group:platform": ["alice@example.com"],
+//		},
+//	}
+//
+// that restricts the sections allowed from the files beneath it. The
+// sections are removed from the document before it is merged.
+type delegation struct {
+	// sections are allowed from the files beneath the parent file, or nil to
+	// allow the same sections as the parent file itself.
+	sections []string
+	// allow is the member the sections were read from, for diagnostics.
+	allow *jwcc.Member
+}
+
+// parseParentFile reads the parent file at path.
+func (c *Combiner) parseParentFile(path string) (*ParsedDocument, error) {
+	doc, err := Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc.delegation = &delegation{}
+	allow := doc.Object.Find("allow")
+	if allow == nil {
+		return doc, nil
+	}
+	sections, err := stringValues(allow.Value)
+	if err != nil {
+		return nil, errorAt(path, allow, RuleInvalidConfig, "invalid parent file: [%s] %v", allow.Key, err)
+	}
+	doc.delegation = &delegation{sections: sections, allow: allow}
+	doc.Object.Members = removeMember(doc.Object, allow.Key.String())
+	return doc, nil
+}
+
+// nearestParentFile returns the parent file in the closest directory above
+// doc at path, other than doc itself, from parentFiles keyed by directory.
+func nearestParentFile(parentFiles map[string]*ParsedDocument, doc *ParsedDocument, path string) *ParsedDocument {
+	dir := filepath.Dir(path)
+	for {
+		if parentFile, ok := parentFiles[dir]; ok && parentFile != doc {
+			return parentFile
+		}
+		next := filepath.Dir(dir)
+		if next == dir {
+			return nil
+		}
+		dir = next
+	}
+}
+
+// ancestorDirs returns the directories containing path, closest first, up to
+// the top of path as given, which is "." for a relative path or the root for
+// an absolute one.
+func ancestorDirs(path string) []string {
+	dirs := []string{}
+	dir := filepath.Dir(filepath.Clean(path))
+	for {
+		dirs = append(dirs, dir)
+		next := filepath.Dir(dir)
+		if next == dir || filepath.Base(dir) == ".." {
+			return dirs
+		}
+		dir = next
+	}
+}
+
+// findParentFiles parses the parent files in the directories containing the
+// child file at path, listed with WithChildFiles, up to the directory of its
+// config file, that are not in parentFiles keyed by directory already. They
+// narrow the sections allowed from path like those found in a child
+// directory, but are not merged unless they are listed too.
+func (c *Combiner) findParentFiles(parentFiles map[string]*ParsedDocument, path string, configPath string) ([]*ParsedDocument, error) {
+	var diags Diagnostics
+	found := []*ParsedDocument{}
+	for _, dir := range ancestorDirs(path) {
+		abs := absPath(dir)
+		if _, ok := parentFiles[abs]; !ok {
+			if parentPath := existingFile(filepath.Join(dir, c.parentFileName)); parentPath != "" {
+				c.logf("parsing [%v] for the files beneath it...\n", parentPath)
+				doc, err := c.parseParentFile(parentPath)
+				if err != nil {
+					diags = append(diags, CollectDiagnostics(err)...)
+				} else {
+					doc.configPath = configPath
+					parentFiles[abs] = doc
+					found = append(found, doc)
+				}
+			}
+		}
+		if configPath != "" && abs == filepath.Dir(absPath(configPath)) {
+			break
+		}
+	}
+	return found, diags.err()
+}
+
+// withDelegators returns childDocs followed by the parent files delegating
+// to them that are not children themselves.
+func withDelegators(childDocs []*ParsedDocument) []*ParsedDocument {
+	docs := slices.Clone(childDocs)
+	seen := map[*ParsedDocument]bool{}
+	for _, doc := range docs {
+		seen[doc] = true
+	}
+	for i := 0; i < len(docs); i++ {
+		if delegator := docs[i].delegator; delegator != nil && !seen[delegator] {
+			seen[delegator] = true
+			docs = append(docs, delegator)
+		}
+	}
+	return docs
+}
+
+// delegate restricts the sections allowed from each child to those allowed
+// by the nearest parent file above it, in turn restricted by the parent
+// files above that one. A parent file can't allow a section it is not
+// allowed itself.
+func delegate(childDocs []*ParsedDocument, defaults map[string]SectionHandler, sections Registry) error {
+	var diags Diagnostics
+	resolved := map[*ParsedDocument]bool{}
+	var resolve func(child *ParsedDocument)
+	resolve = func(child *ParsedDocument) {
+		if resolved[child] {
+			return
+		}
+		resolved[child] = true
+
+		allowed := child.allowedSections(defaults)
+		if child.delegator != nil {
+			resolve(child.delegator)
+			restricted := map[string]SectionHandler{}
+			for name, handler := range allowed {
+				if slices.Contains(child.delegator.delegation.sections, name) {
+					restricted[name] = handler
+				}
+			}
+			child.Sections = restricted
+			allowed = restricted
+		}
+
+		d := child.delegation
+		if d == nil {
+			return
+		}
+		if d.sections == nil {
+			d.sections = slices.Sorted(maps.Keys(allowed))
+			return
+		}
+		delegated := []string{}
+		for _, section := range d.sections {
+			name, ok := sections.canonical(section)
+			if !ok || allowed[name] == nil {
+				diags = append(diags, errorAt(child.Path, d.allow, RuleInvalidConfig, "invalid parent file: section [%s] in [%s] is not allowed from this file", section, d.allow.Key))
+				continue
+			}
+			delegated = append(delegated, name)
+		}
+		d.sections = delegated
+	}
+
+	for _, child := range childDocs {
+		resolve(child)
+	}
+	return diags.err()
+}
@@ -0,0 +1,142 @@
+package combiner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func TestCombineParentFiles(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"parent.hujson": `{}`,
+		"children/eng/_parent.hujson": `{
+			"allow": ["acls", "tagOwners"],
+			"groups": {"group:eng": ["a@example.com"]},
+		}`,
+		"children/eng/web/_parent.hujson": `{"allow": ["tagOwners"]}`,
+		"children/eng/acls.hujson": `{
+			"acls": [{"action": "accept", "src": ["group:eng"], "dst": ["tag:eng:*"]}],
+		}`,
+		"children/eng/web/owners.hujson": `{"tagOwners": {"tag:web": ["group:eng"]}}`,
+		"children/eng/db/_parent.hujson": `{"tagOwners": {"tag:db": ["group:eng"]}}`,
+		"children/eng/db/acls.hujson": `{
+			"acls": [{"action": "accept", "src": ["group:eng"], "dst": ["tag:db:*"]}],
+		}`,
+	})
+
+	result, err := New(
+		WithParent(filepath.Join(dir, "parent.hujson")),
+		WithChildDir(filepath.Join(dir, "children")),
+		WithAllow("acls", "groups", "tagOwners"),
+	).Combine(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if result.Policy.Find("allow") != nil {
+		t.Fatalf("[allow] should be removed from parent files, got [%v]", result.Policy.Members)
+	}
+	if result.Policy.Find("groups") == nil {
+		t.Fatalf("parent file should be merged, got [%v]", result.Policy.Members)
+	}
+	if acls := result.Policy.Find("acls").Value.(*jwcc.Array).Values; len(acls) != 2 {
+		t.Fatalf("acls length should be [2], got [%v]", len(acls))
+	}
+	owners := result.Policy.Find("tagOwners").Value.(*jwcc.Object).Members
+	if len(owners) != 2 || owners[0].Key.String() != "tag:db" || owners[1].Key.String() != "tag:web" {
+		t.Fatalf("tagOwners should be [tag:db tag:web], got [%v]", owners)
+	}
+}
+
+func TestCombineParentFilesNarrowed(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"parent.hujson":                   `{}`,
+		"children/eng/_parent.hujson":     `{"allow": ["acls"]}`,
+		"children/eng/web/_parent.hujson": `{"allow": ["acls", "tagOwners"]}`,
+		"children/eng/owners.hujson":      `{"tagOwners": {"tag:eng": ["a@example.com"]}}`,
+	})
+
+	_, err := New(
+		WithParent(filepath.Join(dir, "parent.hujson")),
+		WithChildDir(filepath.Join(dir, "children")),
+		WithAllow("acls", "tagOwners"),
+	).Combine(context.Background())
+	ds := CollectDiagnostics(err)
+	if len(ds) != 2 {
+		t.Fatalf("expected [2] errors, got [%v]", ds)
+	}
+	expected := filepath.Join(dir, "children", "eng", "web", "_parent.hujson") + ":1:2: invalid parent file: section [tagOwners] in [allow] is not allowed from this file"
+	if ds[0].Error() != expected {
+		t.Fatalf("expected error [%v], got [%v]", expected, ds[0])
+	}
+	expected = filepath.Join(dir, "children", "eng", "owners.hujson") + `:1:2: unsupported section ["tagOwners"], not allowed by [` + filepath.Join(dir, "children", "eng", "_parent.hujson") + "]"
+	if ds[1].Error() != expected {
+		t.Fatalf("expected error [%v], got [%v]", expected, ds[1])
+	}
+}
+
+func TestParseParentFileInvalid(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"_parent.hujson": `{"allow": "acls"}`,
+	})
+
+	_, err := New().parseParentFile(filepath.Join(dir, DefaultParentFile))
+	expected := "invalid parent file: [allow] must be an array of strings"
+	if err == nil || !strings.HasSuffix(err.Error(), expected) {
+		t.Fatalf("expected error [%v], got [%v]", expected, err)
+	}
+}
+
+func TestCombineListedFilesEnforcement(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"repo/departments/" + DefaultConfigFile: `{
+			"allow": {"**": ["acls", "groups"]},
+			"namespaces": {"eng/**": ["tag:eng", "group:eng"]},
+		}`,
+		"repo/departments/eng/_parent.hujson": `{"allow": ["acls"]}`,
+		"repo/departments/eng/web/acls.hujson": `{
+			"acls": [{"action": "accept", "src": ["group:eng"], "dst": ["tag:finance:*"]}],
+			"groups": {"group:eng": ["a@example.com"]},
+		}`,
+	})
+	departments := filepath.Join(dir, "repo", "departments")
+	child := filepath.Join(departments, "eng", "web", "acls.hujson")
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	relChild, err := filepath.Rel(cwd, child)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	messages := func(opt Option) []string {
+		t.Helper()
+		c := New(opt)
+		if c.ConfigPath() == "" {
+			t.Fatalf("config file should be found")
+		}
+		_, err := c.Combine(context.Background())
+		messages := []string{}
+		for _, d := range CollectDiagnostics(err) {
+			messages = append(messages, d.Message)
+		}
+		slices.Sort(messages)
+		return messages
+	}
+
+	expected := messages(WithChildDir(departments))
+	if len(expected) != 2 {
+		t.Fatalf("expected the namespace and parent file to be enforced, got [%v]", expected)
+	}
+	for _, opt := range []Option{WithChildFiles(child), WithChildFiles(relChild)} {
+		if listed := messages(opt); !slices.Equal(listed, expected) {
+			t.Fatalf("listed files should be checked like [%v], got [%v]", expected, listed)
+		}
+	}
+}
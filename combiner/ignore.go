@@ -0,0 +1,121 @@
+package combiner
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// IgnoreFile is the name of the files that skip child files in the directory
+// containing them and beneath it, with the same syntax as .gitignore files.
+const IgnoreFile = ".aclcombinerignore"
+
+// ignoreFile is a parsed IgnoreFile.
+type ignoreFile struct {
+	path string
+	// dir is the directory containing the file, relative to the child
+	// directory and using forward slashes.
+	dir      string
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	// glob is matched with matchGlob against paths relative to the directory
+	// of the ignore file.
+	glob    string
+	negate  bool
+	dirOnly bool
+
+	line int
+	text string
+}
+
+// parseIgnoreFile reads the ignore file at file in dir, or returns nil if
+// there is none.
+func parseIgnoreFile(file string, dir string) (*ignoreFile, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, Diagnostic{Path: file, Severity: SeverityError, Rule: RuleRead, Message: err.Error()}
+	}
+
+	ignore := &ignoreFile{path: file, dir: dir}
+	for i, line := range strings.Split(string(data), "\n") {
+		text := strings.TrimRight(line, " \t\r")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		p := ignorePattern{line: i + 1, text: text}
+		glob := text
+		if strings.HasPrefix(glob, "!") {
+			p.negate = true
+			glob = glob[1:]
+		} else {
+			// A leading backslash escapes "#" and "!".
+			glob = strings.TrimPrefix(glob, `\`)
+		}
+		if strings.HasSuffix(glob, "/") {
+			p.dirOnly = true
+			glob = strings.TrimSuffix(glob, "/")
+		}
+		// Patterns with a slash are relative to the directory of the ignore
+		// file, and others match a name at any depth.
+		if strings.Contains(glob, "/") {
+			glob = strings.TrimPrefix(glob, "/")
+		} else {
+			glob = "**/" + glob
+		}
+
+		err := checkGlob(glob)
+		if err != nil {
+			return nil, Diagnostic{Path: file, Line: i + 1, Column: 1, Severity: SeverityError, Rule: RuleInvalidConfig, Message: fmt.Sprintf("invalid ignore pattern [%s]: %v", text, err)}
+		}
+		p.glob = glob
+		ignore.patterns = append(ignore.patterns, p)
+	}
+	return ignore, nil
+}
+
+// match returns the last pattern in f matching rel, a path relative to the
+// child directory, or nil if there is none.
+func (f *ignoreFile) match(rel string, isDir bool) *ignorePattern {
+	if f.dir != "." {
+		var ok bool
+		rel, ok = strings.CutPrefix(rel, f.dir+"/")
+		if !ok {
+			return nil
+		}
+	}
+
+	var match *ignorePattern
+	for i, p := range f.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if matchGlob(p.glob, rel) {
+			match = &f.patterns[i]
+		}
+	}
+	return match
+}
+
+// ignored returns why rel is ignored by ignores, ordered from the top down so
+// that patterns in deeper ignore files take precedence, if it is.
+func ignored(ignores []*ignoreFile, rel string, isDir bool) (string, bool) {
+	var match *ignorePattern
+	var matchFile *ignoreFile
+	for _, f := range ignores {
+		if p := f.match(rel, isDir); p != nil {
+			match, matchFile = p, f
+		}
+	}
+	if match == nil || match.negate {
+		return "", false
+	}
+	return fmt.Sprintf("ignored by [%s] in [%s:%d]", match.text, matchFile.path, match.line), true
+}
@@ -0,0 +1,73 @@
+package combiner
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnored(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		IgnoreFile: `# drafts and examples
+*.example.hujson
+drafts/
+/fixtures/*.json
+!keep.example.hujson
+\#literal.json
+`,
+		"eng/" + IgnoreFile: `!eng.example.hujson
+acls.json
+`,
+	})
+	root, err := parseIgnoreFile(filepath.Join(dir, IgnoreFile), ".")
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	eng, err := parseIgnoreFile(filepath.Join(dir, "eng", IgnoreFile), "eng")
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	ignores := []*ignoreFile{root, eng}
+
+	tests := []struct {
+		rel     string
+		isDir   bool
+		ignored bool
+	}{
+		{"a.example.hujson", false, true},
+		{"team/a.example.hujson", false, true},
+		{"keep.example.hujson", false, false},
+		{"drafts", true, true},
+		{"team/drafts", true, true},
+		{"drafts", false, false},
+		{"fixtures/a.json", false, true},
+		{"team/fixtures/a.json", false, false},
+		{"#literal.json", false, true},
+		{"eng/eng.example.hujson", false, false},
+		{"eng/acls.json", false, true},
+		{"acls.json", false, false},
+	}
+	for _, tt := range tests {
+		reason, ok := ignored(ignores, tt.rel, tt.isDir)
+		if ok != tt.ignored {
+			t.Fatalf("[%s] should be ignored [%v], got [%v] [%s]", tt.rel, tt.ignored, ok, reason)
+		}
+	}
+
+	reason, _ := ignored(ignores, "eng/acls.json", false)
+	expected := "ignored by [acls.json] in [" + filepath.Join(dir, "eng", IgnoreFile) + ":2]"
+	if reason != expected {
+		t.Fatalf("reason should be [%s], got [%s]", expected, reason)
+	}
+}
+
+func TestParseIgnoreFileInvalid(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		IgnoreFile: "a.json\n[b.json\n",
+	})
+	_, err := parseIgnoreFile(filepath.Join(dir, IgnoreFile), ".")
+	expected := IgnoreFile + ":2:1: invalid ignore pattern [[b.json]: syntax error in pattern"
+	if err == nil || !strings.HasSuffix(err.Error(), expected) {
+		t.Fatalf("expected error [%v], got [%v]", expected, err)
+	}
+}
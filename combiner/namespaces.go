@@ -0,0 +1,116 @@
+package combiner
+
+import (
+	"path"
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// ownsTarget reports whether target, a tag, group or other selector, is in
+// the namespace of d. autogroup:self is always allowed since it only grants
+// access to a user's own devices.
+func (d *ParsedDocument) ownsTarget(target string) bool {
+	if target == "autogroup:self" {
+		return true
+	}
+	for _, pattern := range d.Namespace {
+		if ok, err := path.Match(pattern, target); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNamespaces returns an error for every destination in acls, grants and
+// ssh rules, every tagOwners and groups key, and every autoApprovers entry
+// in the children that is outside the child's namespace, together as
+// Diagnostics. Children without a namespace are not checked.
+func checkNamespaces(childDocs []*ParsedDocument) error {
+	var diags Diagnostics
+	for _, child := range childDocs {
+		if child.Namespace == nil {
+			continue
+		}
+
+		check := func(section string, target string, v jwcc.Value) {
+			if !child.ownsTarget(target) {
+				diags = append(diags, errorAt(child.Path, v, RuleNamespace, "[\"%s\"] in section [%s] is outside the namespace %v", target, section, child.Namespace))
+			}
+		}
+
+		for _, section := range []string{"acls", "grants", "ssh"} {
+			member := child.Object.Find(section)
+			if member == nil {
+				continue
+			}
+			rules, ok := member.Value.(*jwcc.Array)
+			if !ok {
+				continue
+			}
+			for _, rule := range rules.Values {
+				ruleObj, ok := rule.(*jwcc.Object)
+				if !ok {
+					continue
+				}
+				eachString(ruleObj.Find("dst"), func(dst string, v jwcc.Value) {
+					if section == "acls" {
+						dst, _, _ = splitHostPort(dst)
+					}
+					check(section, dst, v)
+				})
+			}
+		}
+
+		for _, section := range []string{"groups", "tagOwners"} {
+			obj := findObjectSection(child.Object, section)
+			if obj == nil {
+				continue
+			}
+			for _, m := range obj.Members {
+				check(section, m.Key.String(), m)
+			}
+		}
+
+		if routes := findObjectSection(child.Object, "autoApprovers.routes"); routes != nil {
+			for _, m := range routes.Members {
+				eachString(m, func(approver string, v jwcc.Value) {
+					check("autoApprovers.routes", approver, v)
+				})
+			}
+		}
+		if autoApprovers := findObjectSection(child.Object, "autoApprovers"); autoApprovers != nil {
+			eachString(autoApprovers.FindKey(ast.TextEqual("exitNode")), func(approver string, v jwcc.Value) {
+				check("autoApprovers.exitNode", approver, v)
+			})
+		}
+	}
+	return diags.err()
+}
+
+// splitHostPort splits a dst entry like "tag:web:80,443" or "[fd7a::1]:22"
+// into its selector and ports.
+func splitHostPort(s string) (string, string, bool) {
+	i := strings.LastIndex(s, ":")
+	if i == -1 {
+		return "", "", false
+	}
+	return strings.Trim(s[:i], "[]"), s[i+1:], true
+}
+
+// eachString calls fn for every string in the array value of m, if any.
+func eachString(m *jwcc.Member, fn func(s string, v jwcc.Value)) {
+	if m == nil {
+		return
+	}
+	arr, ok := m.Value.(*jwcc.Array)
+	if !ok {
+		return
+	}
+	for _, v := range arr.Values {
+		if text, ok := v.Undecorate().(ast.Text); ok {
+			fn(text.String(), v)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckNamespaces(t *testing.T) {
+	_, childDocs := parseTestDocs(t,
+		`{}`,
+		`{
+			"acls": [
+				{"action": "accept", "src": ["group:finance"], "dst": ["tag:finance:443", "tag:finance-db:5432"]},
+				{"action": "accept", "src": ["group:finance"], "dst": ["tag:prod:22", "autogroup:self:*"]},
+			],
+			"grants": [
+				{"src": ["group:finance"], "dst": ["tag:finance", "*"], "ip": ["*"]},
+			],
+			"ssh": [
+				{"action": "accept", "src": ["group:finance"], "dst": ["tag:prod"], "users": ["root"]},
+			],
+			"groups": {
+				"group:finance": [],
+				"group:admins": [],
+			},
+			"tagOwners": {
+				"tag:finance": ["group:finance"],
+				"tag:prod": ["group:finance"],
+			},
+			"autoApprovers": {
+				"routes": {
+					"10.0.0.0/24": ["tag:finance", "tag:prod"],
+				},
+				"exitNode": ["tag:exit"],
+			},
+		}`,
+		`{
+			"acls": [
+				{"action": "accept", "src": ["*"], "dst": ["*:*"]},
+			],
+		}`,
+	)
+	childDocs[0].Namespace = []string{"tag:finance", "tag:finance-*", "group:finance"}
+
+	err := checkNamespaces(childDocs)
+	if err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+
+	expected := []struct {
+		line    int
+		message string
+	}{
+		{4, `["tag:prod"] in section [acls]`},
+		{7, `["*"] in section [grants]`},
+		{10, `["tag:prod"] in section [ssh]`},
+		{14, `["group:admins"] in section [groups]`},
+		{18, `["tag:prod"] in section [tagOwners]`},
+		{22, `["tag:prod"] in section [autoApprovers.routes]`},
+		{24, `["tag:exit"] in section [autoApprovers.exitNode]`},
+	}
+	diags := CollectDiagnostics(err)
+	if len(diags) != len(expected) {
+		t.Fatalf("diagnostics length should be [%v], got [%v]: %v", len(expected), len(diags), err)
+	}
+	for i, e := range expected {
+		if diags[i].Path != "child1" || diags[i].Line != e.line || !strings.HasPrefix(diags[i].Message, e.message) {
+			t.Fatalf("diagnostic should be [child1:%v: %v], got [%v]", e.line, e.message, diags[i])
+		}
+	}
+}
+
+func TestCheckNamespacesAllowed(t *testing.T) {
+	_, childDocs := parseTestDocs(t,
+		`{}`,
+		`{
+			"acls": [
+				{"action": "accept", "src": ["*"], "dst": ["tag:finance:443", "autogroup:self:*"]},
+			],
+		}`,
+	)
+	childDocs[0].Namespace = []string{"tag:finance"}
+
+	err := checkNamespaces(childDocs)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+}
@@ -0,0 +1,86 @@
+package combiner
+
+import (
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// ScalarHandler merges a network policy option such as randomizeClientPort,
+// https://tailscale.com/kb/1337/acl-syntax#network-policy-options. A child
+// may set an option that is not set yet, or repeat the value it is already
+// set to, but setting a different value is a conflict.
+func ScalarHandler() SectionHandler {
+	return func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member) error {
+		if childSection == nil {
+			return nil
+		}
+		return mergeScalar(parent, parentPath, childPath, childSection, sectionKey)
+	}
+}
+
+// DERPMapHandler merges derpMap, combining Regions by region ID. Regions with
+// the same ID and other fields, such as OmitDefaultRegions, are merged like
+// ScalarHandler and must not conflict.
+func DERPMapHandler() SectionHandler {
+	// https://tailscale.com/kb/1118/custom-derp-servers
+	return func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member) error {
+		if childSection == nil {
+			return nil
+		}
+
+		childObj, ok := childSection.Value.(*jwcc.Object)
+		if !ok {
+			return errorAt(childPath, childSection, RuleInvalidFormat, "section [\"%s\"] must be an object", sectionKey)
+		}
+
+		newObj := existingOrNewObject(*parent, sectionKey)
+
+		var diags Diagnostics
+		for _, m := range childObj.Members {
+			key := m.Key.String()
+			if !strings.EqualFold(key, "Regions") {
+				err := mergeScalar(newObj, parentPath, childPath, m, sectionKey+"."+key)
+				diags = append(diags, CollectDiagnostics(err)...)
+				continue
+			}
+
+			regions, ok := m.Value.(*jwcc.Object)
+			if !ok {
+				diags = append(diags, errorAt(childPath, m, RuleInvalidFormat, "[\"%s.%s\"] must be an object", sectionKey, key))
+				continue
+			}
+
+			newRegions := existingOrNewObject(*newObj, key)
+			for _, region := range regions.Members {
+				err := mergeScalar(newRegions, parentPath, childPath, region, sectionKey+"."+key+"."+region.Key.String())
+				diags = append(diags, CollectDiagnostics(err)...)
+			}
+			upsertMember(newObj, key, newRegions)
+		}
+
+		upsertMember(parent, sectionKey, newObj)
+		return diags.err()
+	}
+}
+
+// mergeScalar adds m, from the file at childPath, to obj unless obj already
+// has a member with the same key. It returns a conflict if that member has a
+// different value. name is the dot-separated path of m used in messages.
+func mergeScalar(obj *jwcc.Object, parentPath string, childPath string, m *jwcc.Member, name string) error {
+	existing := obj.Find(m.Key.String())
+	if existing == nil {
+		obj.Members = append(obj.Members, copyMember(m, childPath))
+		return nil
+	}
+
+	if valueKey(existing.Value) == valueKey(m.Value) {
+		return nil
+	}
+
+	source, ok := Provenance(existing)
+	if !ok {
+		source = parentPath
+	}
+	return errorAt(childPath, m, RuleConflict, "[\"%s\"] is set to [%s], conflicting with [%s] from [%s]", name, m.Value.Undecorate().JSON(), existing.Value.Undecorate().JSON(), source)
+}
@@ -0,0 +1,130 @@
+package combiner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func TestScalarHandler(t *testing.T) {
+	parentDoc, childDocs := parseTestDocs(t,
+		`{"randomizeClientPort": true}`,
+		`{"randomizeClientPort": true, "disableIPv4": false}`,
+		`{"disableIPv4": false}`,
+	)
+
+	sections := map[string]SectionHandler{
+		"randomizeClientPort": ScalarHandler(),
+		"disableIPv4":         ScalarHandler(),
+	}
+	err := New().mergeDocs(sections, parentDoc, childDocs)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(parentDoc.Object.Members) != 2 {
+		t.Fatalf("parent members length should be [2], got [%v]", len(parentDoc.Object.Members))
+	}
+	disableIPv4 := parentDoc.Object.Find("disableIPv4")
+	if path, _ := Provenance(disableIPv4); path != "child1" {
+		t.Fatalf("provenance should be [child1], got [%v]", path)
+	}
+}
+
+func TestScalarHandlerConflict(t *testing.T) {
+	parentDoc, childDocs := parseTestDocs(t,
+		`{"RandomizeClientPort": true}`,
+		`{"randomizeClientPort": false}`,
+		`{"OneCGNATRoute": "mac-always"}`,
+		`{"OneCGNATRoute": "mac-never"}`,
+	)
+
+	sections := map[string]SectionHandler{
+		"randomizeClientPort": ScalarHandler(),
+		"OneCGNATRoute":       ScalarHandler(),
+	}
+	err := New().mergeDocs(sections, parentDoc, childDocs)
+
+	expected := []string{
+		`child1:1:2: ["randomizeClientPort"] is set to [false], conflicting with [true] from [parent]`,
+		`child3:1:2: ["OneCGNATRoute"] is set to ["mac-never"], conflicting with ["mac-always"] from [child2]`,
+	}
+	for _, e := range expected {
+		if err == nil || !strings.Contains(err.Error(), e) {
+			t.Fatalf("error should contain [%v], got [%v]", e, err)
+		}
+	}
+	for _, d := range CollectDiagnostics(err) {
+		if d.Rule != RuleConflict && d.Rule != RuleUnsupportedSection {
+			t.Fatalf("unexpected diagnostic [%v]", d)
+		}
+	}
+}
+
+func TestDERPMapHandler(t *testing.T) {
+	parentDoc, childDocs := parseTestDocs(t,
+		`{
+			"derpMap": {
+				"OmitDefaultRegions": true,
+				"Regions": {
+					"900": {"RegionID": 900, "RegionCode": "parent"},
+				},
+			},
+		}`,
+		`{
+			"derpMap": {
+				"OmitDefaultRegions": true,
+				"Regions": {
+					"900": {"RegionID": 900, "RegionCode": "parent"},
+					"901": {"RegionID": 901, "RegionCode": "child"},
+				},
+			},
+		}`,
+	)
+
+	err := New().mergeDocs(map[string]SectionHandler{"derpMap": DERPMapHandler()}, parentDoc, childDocs)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	derpMap := parentDoc.Object.Find("derpMap").Value.(*jwcc.Object)
+	if len(derpMap.Members) != 2 {
+		t.Fatalf("derpMap members length should be [2], got [%v]", len(derpMap.Members))
+	}
+	regions := derpMap.Find("Regions").Value.(*jwcc.Object)
+	if len(regions.Members) != 2 {
+		t.Fatalf("regions length should be [2], got [%v]", len(regions.Members))
+	}
+}
+
+func TestDERPMapHandlerConflict(t *testing.T) {
+	parentDoc, childDocs := parseTestDocs(t,
+		`{"derpMap": {"Regions": {"900": {"RegionID": 900, "RegionCode": "parent"}}}}`,
+		`{"derpMap": {"OmitDefaultRegions": true, "Regions": {"900": {"RegionID": 900, "RegionCode": "child"}}}}`,
+		`{"derpMap": {"OmitDefaultRegions": false}}`,
+	)
+
+	err := New().mergeDocs(map[string]SectionHandler{"derpMap": DERPMapHandler()}, parentDoc, childDocs)
+
+	ds := CollectDiagnostics(err)
+	if len(ds) != 2 {
+		t.Fatalf("expected [2] conflicts, got [%v]", ds)
+	}
+	if !strings.Contains(ds[0].Message, `["derpMap.Regions.900"]`) || !strings.Contains(ds[0].Message, "from [parent]") {
+		t.Fatalf("unexpected conflict [%v]", ds[0])
+	}
+	if !strings.Contains(ds[1].Message, `["derpMap.OmitDefaultRegions"]`) || !strings.Contains(ds[1].Message, "from [child1]") {
+		t.Fatalf("unexpected conflict [%v]", ds[1])
+	}
+}
+
+func TestDERPMapHandlerInvalid(t *testing.T) {
+	parentDoc, childDocs := parseTestDocs(t, `{}`, `{"derpMap": []}`)
+
+	err := New().mergeDocs(map[string]SectionHandler{"derpMap": DERPMapHandler()}, parentDoc, childDocs)
+	ds := CollectDiagnostics(err)
+	if len(ds) != 1 || ds[0].Rule != RuleInvalidFormat {
+		t.Fatalf("expected an invalid format error, got [%v]", ds)
+	}
+}
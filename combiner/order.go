@@ -0,0 +1,144 @@
+package combiner
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// MergeOrder controls the order child files are merged in, which decides the
+// order of their entries in array sections such as acls.
+type MergeOrder string
+
+const (
+	// OrderLexical merges child files in order of their paths, compared by
+	// directory, with the files of each child directory and the file list in
+	// turn. The parent file of a directory comes before the files beneath
+	// it.
+	OrderLexical MergeOrder = "lexical"
+	// OrderPriority merges child files with a higher priority first, set
+	// with a comment at the top of the file such as:
+	//
+	//	// acl-combiner: priority=10
+	//
+	// Files without one have priority 0, and files with the same priority
+	// are merged in lexical order.
+	OrderPriority MergeOrder = "priority"
+	// OrderConfig merges child files in the order of the first glob they
+	// match under "order" in the config file, followed by the files that
+	// don't match any glob. Files matching the same glob are merged in
+	// lexical order.
+	OrderConfig MergeOrder = "config"
+)
+
+// MergeOrders are the orders accepted by WithOrder.
+var MergeOrders = []MergeOrder{OrderLexical, OrderPriority, OrderConfig}
+
+// priorityDirective starts the comment setting the priority of a child file
+// for OrderPriority.
+const priorityDirective = "acl-combiner: priority="
+
+// sortFiles sorts files into the order they are merged in.
+func (c *Combiner) sortFiles(files []ChildFile) error {
+	switch c.order {
+	case OrderLexical:
+	case OrderPriority:
+		var diags Diagnostics
+		for i, f := range files {
+			if !f.Merge {
+				continue
+			}
+			priority, err := readPriority(f.Path)
+			if err != nil {
+				diags = append(diags, CollectDiagnostics(err)...)
+				continue
+			}
+			files[i].rank = -priority
+			if priority != 0 {
+				files[i].Reason = fmt.Sprintf("%s, priority %d", f.Reason, priority)
+			}
+		}
+		if err := diags.err(); err != nil {
+			return err
+		}
+	case OrderConfig:
+		var config *combinerConfig
+		if path := c.ConfigPath(); path != "" {
+			var err error
+			config, err = c.loadConfig(path)
+			if err != nil {
+				return err
+			}
+		}
+		if config == nil || len(config.Order) == 0 {
+			return Diagnostic{Severity: SeverityError, Rule: RuleInvalidArgument, Message: fmt.Sprintf("merge order [%s] requires a config file with [order]", c.order)}
+		}
+		for i, f := range files {
+			rank, glob := config.order(f.Path)
+			files[i].rank = rank
+			if f.Merge && glob != "" {
+				files[i].Reason = fmt.Sprintf("%s, ordered by [%s]", f.Reason, glob)
+			}
+		}
+	default:
+		return Diagnostic{Severity: SeverityError, Rule: RuleInvalidArgument, Message: fmt.Sprintf("unsupported merge order [%s], expected one of %v", c.order, MergeOrders)}
+	}
+
+	slices.SortStableFunc(files, func(a, b ChildFile) int {
+		return cmp.Or(
+			cmp.Compare(a.rank, b.rank),
+			cmp.Compare(a.sourceIndex, b.sourceIndex),
+			slices.Compare(a.key, b.key),
+		)
+	})
+	return nil
+}
+
+// sortKey returns the key files found at rel, relative to their child
+// directory or as listed, are sorted by for OrderLexical. A parent file is
+// keyed by its directory so that it comes before the files beneath it.
+func (c *Combiner) sortKey(rel string) []string {
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	if path, ok := strings.CutSuffix(rel, "/"+c.parentFileName); ok {
+		rel = path
+	} else if rel == c.parentFileName {
+		rel = "."
+	}
+	if rel == "." {
+		return nil
+	}
+	return strings.Split(rel, "/")
+}
+
+// readPriority returns the priority set in the child file at path, or 0 if
+// there is none. Files that fail to parse have priority 0, and the error is
+// reported when they are merged.
+func readPriority(path string) (int, error) {
+	doc, err := Parse(path)
+	if err != nil {
+		return 0, nil
+	}
+	return filePriority(doc)
+}
+
+// filePriority returns the priority set in the comments at the top of doc,
+// or 0 if there is none.
+func filePriority(doc *ParsedDocument) (int, error) {
+	for _, line := range jwcc.CleanComments(doc.Object.Comments().Before...) {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), priorityDirective)
+		if !ok {
+			continue
+		}
+		priority, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return 0, errorAt(doc.Path, doc.Object, RuleInvalidFormat, "invalid priority [%s], expected an integer", strings.TrimSpace(value))
+		}
+		return priority, nil
+	}
+	return 0, nil
+}
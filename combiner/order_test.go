@@ -0,0 +1,160 @@
+package combiner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// orderTestFiles maps child files to the destination of their acl.
+var orderTestFiles = map[string]string{
+	"c.hujson":          "tag:c:*",
+	"B.hujson":          "tag:upper-b:*",
+	"a/_parent.hujson":  "tag:a-parent:*",
+	"a/z.hujson":        "tag:a-z:*",
+	"a/b/y.hujson":      "tag:a-b-y:*",
+	"a.hujson":          "tag:a-file:*",
+	"a-b/x.hujson":      "tag:a-dash-b:*",
+	"priority/p.hujson": "tag:priority:*",
+}
+
+func orderTestContent(dst string) string {
+	return `{"acls": [{"action": "accept", "src": ["*"], "dst": ["` + dst + `"]}]}`
+}
+
+func combineOrdered(t *testing.T, opts ...Option) (*Result, []byte) {
+	t.Helper()
+	opts = append(opts, WithAllow("acls"), WithProvenance(ProvenanceNone))
+	result, err := New(opts...).Combine(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	formatted, err := Format(result.Policy)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	return result, formatted
+}
+
+func aclDestinations(policy *jwcc.Object) []string {
+	dsts := []string{}
+	for _, acl := range policy.Find("acls").Value.(*jwcc.Array).Values {
+		dst := acl.(*jwcc.Object).Find("dst").Value.(*jwcc.Array).Values[0]
+		dsts = append(dsts, dst.(*jwcc.Datum).Value.String())
+	}
+	return dsts
+}
+
+func TestCombineOrderLexical(t *testing.T) {
+	files := map[string]string{}
+	for name, dst := range orderTestFiles {
+		files["real/"+name] = orderTestContent(dst)
+		files["store/"+strings.ReplaceAll(name, "/", "_")] = orderTestContent(dst)
+	}
+	dir := writeTestFiles(t, files)
+
+	// linked has the same files as real, as symlinks to files named
+	// differently in store.
+	for name := range orderTestFiles {
+		link := filepath.Join(dir, "linked", name)
+		err := os.MkdirAll(filepath.Dir(link), 0755)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		err = os.Symlink(filepath.Join(dir, "store", strings.ReplaceAll(name, "/", "_")), link)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+	}
+
+	result, expected := combineOrdered(t, WithChildDir(filepath.Join(dir, "real")))
+	dsts := aclDestinations(result.Policy)
+	expectedDsts := []string{"tag:upper-b:*", "tag:a-parent:*", "tag:a-b-y:*", "tag:a-z:*", "tag:a-dash-b:*", "tag:a-file:*", "tag:c:*", "tag:priority:*"}
+	if !slices.Equal(dsts, expectedDsts) {
+		t.Fatalf("acls should be in order [%v], got [%v]", expectedDsts, dsts)
+	}
+
+	_, linked := combineOrdered(t, WithChildDir(filepath.Join(dir, "linked")))
+	if string(linked) != string(expected) {
+		t.Fatalf("combining symlinks should produce [%s], got [%s]", expected, linked)
+	}
+
+	// Listing the files in any order produces the same output.
+	names := []string{}
+	for name := range orderTestFiles {
+		names = append(names, filepath.Join(dir, "real", name))
+	}
+	slices.Sort(names)
+	reversed := slices.Clone(names)
+	slices.Reverse(reversed)
+	for _, list := range [][]string{names, reversed} {
+		_, listed := combineOrdered(t, WithChildFiles(list...))
+		if string(listed) != string(expected) {
+			t.Fatalf("combining [%v] should produce [%s], got [%s]", list, expected, listed)
+		}
+	}
+}
+
+func TestCombineOrderPriority(t *testing.T) {
+	files := map[string]string{}
+	for name, dst := range orderTestFiles {
+		files["children/"+name] = orderTestContent(dst)
+	}
+	files["children/priority/p.hujson"] = "// acl-combiner: priority=10\n" + orderTestContent("tag:priority:*")
+	files["children/c.hujson"] = "// owned by team c\n// acl-combiner: priority=-1\n" + orderTestContent("tag:c:*")
+	dir := writeTestFiles(t, files)
+
+	result, _ := combineOrdered(t, WithChildDir(filepath.Join(dir, "children")), WithOrder(OrderPriority))
+	dsts := aclDestinations(result.Policy)
+	expected := []string{"tag:priority:*", "tag:upper-b:*", "tag:a-parent:*", "tag:a-b-y:*", "tag:a-z:*", "tag:a-dash-b:*", "tag:a-file:*", "tag:c:*"}
+	if !slices.Equal(dsts, expected) {
+		t.Fatalf("acls should be in order [%v], got [%v]", expected, dsts)
+	}
+	formatted, err := Format(result.Policy)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if !strings.HasPrefix(string(formatted), "// "+generatedMarker+", children merged in priority order. DO NOT EDIT.\n") {
+		t.Fatalf("output should record the merge order, got [%s]", formatted)
+	}
+}
+
+func TestCombineOrderPriorityInvalid(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"children/a.hujson": "// acl-combiner: priority=high\n{}",
+	})
+
+	_, err := New(WithChildDir(filepath.Join(dir, "children")), WithAllow("acls"), WithOrder(OrderPriority)).Combine(context.Background())
+	expected := filepath.Join(dir, "children", "a.hujson") + ":2:1: invalid priority [high], expected an integer"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error [%v], got [%v]", expected, err)
+	}
+}
+
+func TestCombineOrderConfig(t *testing.T) {
+	files := map[string]string{
+		"children/" + DefaultConfigFile: `{"order": ["priority/**", "a/b/**", "a/**"]}`,
+	}
+	for name, dst := range orderTestFiles {
+		files["children/"+name] = orderTestContent(dst)
+	}
+	dir := writeTestFiles(t, files)
+
+	result, _ := combineOrdered(t, WithChildDir(filepath.Join(dir, "children")), WithOrder(OrderConfig))
+	dsts := aclDestinations(result.Policy)
+	expected := []string{"tag:priority:*", "tag:a-b-y:*", "tag:a-parent:*", "tag:a-z:*", "tag:upper-b:*", "tag:a-dash-b:*", "tag:a-file:*", "tag:c:*"}
+	if !slices.Equal(dsts, expected) {
+		t.Fatalf("acls should be in order [%v], got [%v]", expected, dsts)
+	}
+
+	other := writeTestFiles(t, map[string]string{"c.hujson": orderTestContent("tag:c:*")})
+	_, err := New(WithChildFiles(filepath.Join(other, "c.hujson")), WithAllow("acls"), WithOrder(OrderConfig)).Combine(context.Background())
+	if err == nil || err.Error() != "merge order [config] requires a config file with [order]" {
+		t.Fatalf("expected an error for a missing config file, got [%v]", err)
+	}
+}
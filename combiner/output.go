@@ -0,0 +1,168 @@
+package combiner
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+	"github.com/tailscale/hujson"
+	"gopkg.in/yaml.v3"
+)
+
+// OutputFormat is a format the combined policy can be written in.
+type OutputFormat string
+
+const (
+	// OutputHuJSON is HuJSON as written by Format, with comments and
+	// trailing commas.
+	OutputHuJSON OutputFormat = "hujson"
+	// OutputJSON is standard JSON without comments, indented.
+	OutputJSON OutputFormat = "json"
+	// OutputJSONMin is standard JSON without comments or whitespace.
+	OutputJSONMin OutputFormat = "json-min"
+	// OutputYAML is YAML, keeping the comments of OutputHuJSON.
+	OutputYAML OutputFormat = "yaml"
+)
+
+// OutputFormats are the formats accepted by FormatAs.
+var OutputFormats = []OutputFormat{OutputHuJSON, OutputJSON, OutputJSONMin, OutputYAML}
+
+// FormatAs returns doc in format. Every format is derived from the HuJSON
+// returned by Format, and holds the same values, only the comments and
+// layout differ.
+func FormatAs(doc *jwcc.Object, format OutputFormat) ([]byte, error) {
+	formatted, err := Format(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case OutputHuJSON:
+		return formatted, nil
+	case OutputJSON, OutputJSONMin:
+		minimized, err := hujson.Minimize(formatted)
+		if err != nil {
+			return nil, fmt.Errorf("error formatting: %w", err)
+		}
+		if format == OutputJSONMin {
+			return append(minimized, '\n'), nil
+		}
+		return hujson.Format(minimized)
+	case OutputYAML:
+		return formatYAML(doc, formatted)
+	default:
+		return nil, fmt.Errorf("unsupported output format [%s], expected one of %v", format, OutputFormats)
+	}
+}
+
+// formatYAML returns doc, formatted as HuJSON, as YAML. The values are read
+// from formatted as standard JSON, which is valid YAML, so they are the same
+// in both, and the comments are copied from doc.
+func formatYAML(doc *jwcc.Object, formatted []byte) ([]byte, error) {
+	standard, err := hujson.Standardize(formatted)
+	if err != nil {
+		return nil, fmt.Errorf("error formatting: %w", err)
+	}
+	var node yaml.Node
+	err = yaml.Unmarshal(standard, &node)
+	if err != nil {
+		return nil, fmt.Errorf("error formatting: %w", err)
+	}
+
+	node.HeadComment = yamlComment(doc.Comments().Before...)
+	root := node.Content[0]
+	blockStyle(root)
+	copyComments(root, doc)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err = enc.Encode(&node)
+	if err != nil {
+		return nil, fmt.Errorf("error formatting: %w", err)
+	}
+	err = enc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error formatting: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// blockStyle clears the style of n and the nodes beneath it, so mappings and
+// sequences are written in block style, and strings are only quoted where
+// they need to be.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	if n.Kind == yaml.ScalarNode && n.ShortTag() == "!!str" && yaml11NonString(n.Value) {
+		n.Style = yaml.DoubleQuotedStyle
+	}
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// yaml11Number matches plain scalars that YAML 1.1 may read as numbers,
+// including octal, binary, base 60 and underscored forms.
+var yaml11Number = regexp.MustCompile(`^[-+]?([0-9][0-9_]*(:[0-5]?[0-9])+(\.[0-9_]*)?|[0-9_]*(\.[0-9_]*)?([eE][-+]?[0-9]+)?|0b[01_]+|0x[0-9a-fA-F_]+|\.(inf|Inf|INF|nan|NaN|NAN))$`)
+
+// yaml11NonString reports whether the string s, written as a plain scalar,
+// would be read back as a boolean, null or number by YAML 1.1 consumers,
+// which yaml.v3 only quotes for YAML 1.2.
+func yaml11NonString(s string) bool {
+	switch s {
+	case "y", "Y", "yes", "Yes", "YES", "n", "N", "no", "No", "NO",
+		"true", "True", "TRUE", "false", "False", "FALSE",
+		"on", "On", "ON", "off", "Off", "OFF",
+		"~", "null", "Null", "NULL", "":
+		return true
+	}
+	return yaml11Number.MatchString(s)
+}
+
+// copyComments copies the comments on the members and elements of v to the
+// YAML node n read from the same value.
+func copyComments(n *yaml.Node, v jwcc.Value) {
+	switch t := v.(type) {
+	case *jwcc.Object:
+		for i, m := range t.Members {
+			key, value := n.Content[2*i], n.Content[2*i+1]
+			key.HeadComment = yamlComment(slices.Concat(m.Comments().Before, m.Value.Comments().Before)...)
+			line := yamlComment(m.Comments().Line, m.Value.Comments().Line)
+			if value.Kind == yaml.ScalarNode {
+				value.LineComment = line
+			} else {
+				key.LineComment = line
+			}
+			copyComments(value, m.Value)
+		}
+	case *jwcc.Array:
+		for i, e := range t.Values {
+			item := n.Content[i]
+			if item.Kind == yaml.ScalarNode {
+				item.HeadComment = yamlComment(e.Comments().Before...)
+				item.LineComment = yamlComment(e.Comments().Line)
+			} else {
+				item.HeadComment = yamlComment(slices.Concat(e.Comments().Before, []string{e.Comments().Line})...)
+			}
+			copyComments(item, e)
+		}
+	}
+}
+
+// yamlComment returns the text of HuJSON comments as a YAML comment.
+func yamlComment(comments ...string) string {
+	nonEmpty := []string{}
+	for _, c := range comments {
+		if c != "" {
+			nonEmpty = append(nonEmpty, c)
+		}
+	}
+	lines := []string{}
+	for _, line := range jwcc.CleanComments(nonEmpty...) {
+		lines = append(lines, strings.TrimRight("# "+line, " "))
+	}
+	return strings.Join(lines, "\n")
+}
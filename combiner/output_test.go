@@ -0,0 +1,144 @@
+package combiner
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+	"github.com/tailscale/hujson"
+	"gopkg.in/yaml.v3"
+)
+
+const outputTestPolicy = `// generated
+{
+	// from ` + "`a.hujson`" + `
+	"acls": [
+		{"action": "accept", "src": ["*"], "dst": ["tag:a:22"]}, // ssh
+	],
+	"hosts": {
+		"yes":      "100.64.0.1",
+		"on":       "null",
+		"# hash":   "a: b",
+		"multi":    "line\none",
+		"unicode":  "café",
+	},
+	"values": [1, 1.5, -2e3, true, false, null, "1.0", "0x10", "", [], {}],
+}`
+
+// decodeJSON returns the values in data, via JSON so the number types match
+// whichever format data was in.
+func decodeJSON(t *testing.T, data []byte, format OutputFormat) any {
+	t.Helper()
+	if format == OutputYAML {
+		var v any
+		err := yaml.Unmarshal(data, &v)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		data, err = json.Marshal(v)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+	} else {
+		var err error
+		data, err = hujson.Standardize(data)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+	}
+	var v any
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	return v
+}
+
+func TestFormatAs(t *testing.T) {
+	for _, policy := range []string{outputTestPolicy, ACL_PARENT} {
+		doc, err := jwcc.Parse(strings.NewReader(policy))
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		obj := doc.Value.(*jwcc.Object)
+
+		formatted, err := Format(obj)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		expected := decodeJSON(t, formatted, OutputHuJSON)
+
+		for _, format := range OutputFormats {
+			out, err := FormatAs(obj, format)
+			if err != nil {
+				t.Fatalf("expected no error, got [%v]", err)
+			}
+			if format == OutputJSON || format == OutputJSONMin {
+				if !json.Valid(out) {
+					t.Fatalf("%s output should be standard JSON, got [%s]", format, out)
+				}
+			}
+			if v := decodeJSON(t, out, format); !reflect.DeepEqual(v, expected) {
+				t.Fatalf("%s output should hold [%v], got [%v]", format, expected, v)
+			}
+		}
+	}
+}
+
+func TestFormatAsYAMLComments(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(outputTestPolicy))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	obj := doc.Value.(*jwcc.Object)
+
+	out, err := FormatAs(obj, OutputYAML)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	for _, comment := range []string{"# generated\n", "# from `a.hujson`\n", "# ssh\n"} {
+		if !strings.Contains(string(out), comment) {
+			t.Fatalf("output should contain [%s], got [%s]", comment, out)
+		}
+	}
+	if len(obj.Comments().Before) != 1 {
+		t.Fatalf("formatting should not change the comments of the policy, got [%v]", obj.Comments().Before)
+	}
+
+	min, err := FormatAs(obj, OutputJSONMin)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if strings.Count(string(min), "\n") != 1 || strings.Contains(string(min), "generated") {
+		t.Fatalf("json-min output should be one line without comments, got [%s]", min)
+	}
+}
+
+func TestFormatAsYAMLQuoting(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{"hosts": {
+		"yes": "no", "on": "off", "y": "n", "null": "~", "a": "12:30", "b": "0755", "c": "plain", "d": "100.64.0.1",
+	}}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	out, err := FormatAs(doc.Value.(*jwcc.Object), OutputYAML)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	// Strings YAML 1.1 would read as booleans, null or numbers stay quoted.
+	for _, line := range []string{`"yes": "no"`, `"on": "off"`, `"y": "n"`, `"null": "~"`, `a: "12:30"`, `b: "0755"`, `c: plain`, `d: 100.64.0.1`} {
+		if !strings.Contains(string(out), "  "+line+"\n") {
+			t.Fatalf("output should contain [%s], got [%s]", line, out)
+		}
+	}
+}
+
+func TestFormatAsUnsupported(t *testing.T) {
+	_, err := FormatAs(&jwcc.Object{}, "xml")
+	if err == nil || err.Error() != "unsupported output format [xml], expected one of [hujson json json-min yaml]" {
+		t.Fatalf("expected an error for an unsupported format, got [%v]", err)
+	}
+}
@@ -0,0 +1,130 @@
+package combiner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// ProvenanceStyle controls the comments recording the file each entry of
+// the combined policy came from.
+type ProvenanceStyle string
+
+const (
+	// ProvenanceNone adds no comments.
+	ProvenanceNone ProvenanceStyle = "none"
+	// ProvenanceFirst adds a comment before the first entry of each run of
+	// entries from the same file.
+	ProvenanceFirst ProvenanceStyle = "first"
+	// ProvenanceEvery adds a comment before every entry.
+	ProvenanceEvery ProvenanceStyle = "every"
+	// ProvenanceTrailing adds a comment at the end of the line of every
+	// entry, or before the entry if it already has a comment there.
+	ProvenanceTrailing ProvenanceStyle = "trailing"
+)
+
+// ProvenanceStyles are the styles accepted by WithProvenance.
+var ProvenanceStyles = []ProvenanceStyle{ProvenanceNone, ProvenanceFirst, ProvenanceEvery, ProvenanceTrailing}
+
+// pathComment records path as the file val came from, keeping the comments
+// val already has other than an earlier provenance comment.
+func pathComment(val jwcc.Value, path string) {
+	removeProvenance(val)
+	com := val.Comments()
+	com.Before = append([]string{fmt.Sprintf("from `%s`", path)}, com.Before...)
+}
+
+// Provenance returns the path recorded in a comment added when combining,
+// if v has one.
+func Provenance(v jwcc.Value) (string, bool) {
+	for _, c := range v.Comments().Before {
+		if path, ok := provenanceComment(c); ok {
+			return path, true
+		}
+	}
+	return provenanceComment(v.Comments().Line)
+}
+
+// provenanceComment returns the path in c if it is a comment added by
+// pathComment.
+func provenanceComment(c string) (string, bool) {
+	lines := jwcc.CleanComments(c)
+	if len(lines) != 1 {
+		return "", false
+	}
+	path, ok := strings.CutPrefix(lines[0], "from `")
+	if !ok || !strings.HasSuffix(path, "`") {
+		return "", false
+	}
+	return strings.TrimSuffix(path, "`"), true
+}
+
+// applyProvenance rewrites the provenance comments added to every entry of
+// doc while merging to match style. Each section is handled on its own, so
+// the first entry of a section always keeps its comment with
+// ProvenanceFirst.
+func applyProvenance(doc *jwcc.Object, style ProvenanceStyle) {
+	for _, section := range doc.Members {
+		restyleProvenance(section, "", style)
+		applyProvenanceTo(section.Value, style)
+	}
+}
+
+// applyProvenanceTo applies style to the entries nested in v, such as the
+// members of a group.
+func applyProvenanceTo(v jwcc.Value, style ProvenanceStyle) {
+	previous := ""
+	switch t := v.(type) {
+	case *jwcc.Array:
+		for _, v := range t.Values {
+			previous = restyleProvenance(v, previous, style)
+			applyProvenanceTo(v, style)
+		}
+	case *jwcc.Object:
+		for _, m := range t.Members {
+			previous = restyleProvenance(m, previous, style)
+			applyProvenanceTo(m.Value, style)
+		}
+	}
+}
+
+// restyleProvenance applies style to the provenance comment of v, where
+// previous is the provenance of the entry before it, and returns the
+// provenance of v for the entry after it.
+func restyleProvenance(v jwcc.Value, previous string, style ProvenanceStyle) string {
+	path, ok := Provenance(v)
+	if !ok {
+		return previous
+	}
+
+	switch style {
+	case ProvenanceNone:
+		removeProvenance(v)
+	case ProvenanceFirst:
+		if path == previous {
+			removeProvenance(v)
+		}
+	case ProvenanceTrailing:
+		com := v.Comments()
+		if com.Line == "" {
+			removeProvenance(v)
+			com.Line = fmt.Sprintf("// from `%s`", path)
+		}
+	}
+	return path
+}
+
+func removeProvenance(v jwcc.Value) {
+	com := v.Comments()
+	var before []string
+	for _, c := range com.Before {
+		if _, ok := provenanceComment(c); !ok {
+			before = append(before, c)
+		}
+	}
+	com.Before = before
+	if _, ok := provenanceComment(com.Line); ok {
+		com.Line = ""
+	}
+}
@@ -0,0 +1,132 @@
+package combiner
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func TestPathCommentKeepsComments(t *testing.T) {
+	parent, err := jwcc.Parse(strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	parentObj := parent.Value.(*jwcc.Object)
+
+	child, err := jwcc.Parse(strings.NewReader(`{
+		"acls": [
+			// ticket SEC-123: allow db access
+			{"action": "accept", "src": ["*"], "dst": ["tag:db:5432"]},
+		],
+		"hosts": {
+			// ticket SEC-124
+			"db": "100.64.0.1", // primary
+		},
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	childObj := child.Value.(*jwcc.Object)
+
+	err = ArrayHandler()("acls", "parent", parentObj, "child", childObj.Find("acls"))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	err = ObjectHandler()("hosts", "parent", parentObj, "child", childObj.Find("hosts"))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	acl := parentObj.Find("acls").Value.(*jwcc.Array).Values[0]
+	expected := []string{"from `child`", "ticket SEC-123: allow db access"}
+	if got := jwcc.CleanComments(acl.Comments().Before...); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("comments should be %v, got %v", expected, got)
+	}
+
+	host := parentObj.Find("hosts").Value.(*jwcc.Object).Members[0]
+	expected = []string{"from `child`", "ticket SEC-124"}
+	if got := jwcc.CleanComments(host.Comments().Before...); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("comments should be %v, got %v", expected, got)
+	}
+	if got := jwcc.CleanComments(host.Comments().Line); len(got) != 1 || got[0] != "primary" {
+		t.Fatalf("line comment should be [primary], got %v", got)
+	}
+
+	pathComment(acl, "other")
+	expected = []string{"from `other`", "ticket SEC-123: allow db access"}
+	if got := jwcc.CleanComments(acl.Comments().Before...); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("comments should be %v, got %v", expected, got)
+	}
+}
+
+func TestApplyProvenance(t *testing.T) {
+	tests := []struct {
+		style  ProvenanceStyle
+		before []string
+		line   []string
+	}{
+		{style: ProvenanceNone, before: []string{"", "", "", ""}, line: []string{"", "", "note", ""}},
+		{style: ProvenanceFirst, before: []string{"from `a`", "", "from `b`", ""}, line: []string{"", "", "note", ""}},
+		{style: ProvenanceEvery, before: []string{"from `a`", "from `a`", "from `b`", "from `b`"}, line: []string{"", "", "note", ""}},
+		{style: ProvenanceTrailing, before: []string{"", "", "from `b`", ""}, line: []string{"from `a`", "from `a`", "note", "from `b`"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.style), func(t *testing.T) {
+			doc, err := jwcc.Parse(strings.NewReader(`{"acls": [1, 2, 3, // note
+				4]}`))
+			if err != nil {
+				t.Fatalf("expected no error, got [%v]", err)
+			}
+			obj := doc.Value.(*jwcc.Object)
+			values := obj.Find("acls").Value.(*jwcc.Array).Values
+			for i, path := range []string{"a", "a", "b", "b"} {
+				pathComment(values[i], path)
+			}
+
+			applyProvenance(obj, tt.style)
+
+			for i, v := range values {
+				before := strings.Join(jwcc.CleanComments(v.Comments().Before...), ",")
+				if before != tt.before[i] {
+					t.Fatalf("comments before value [%d] should be [%v], got [%v]", i, tt.before[i], before)
+				}
+				line := strings.Join(jwcc.CleanComments(v.Comments().Line), ",")
+				if line != tt.line[i] {
+					t.Fatalf("line comment of value [%d] should be [%v], got [%v]", i, tt.line[i], line)
+				}
+			}
+		})
+	}
+}
+
+func TestCombineProvenance(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"parent.hujson":        `{"acls": [{"action": "accept", "src": ["*"], "dst": ["*:22"]}]}`,
+		"children/acls.hujson": `{"acls": [{"action": "accept", "src": ["a@example.com"], "dst": ["tag:a:*"]}]}`,
+	})
+
+	result, err := New(
+		WithParent(filepath.Join(dir, "parent.hujson")),
+		WithChildDir(filepath.Join(dir, "children")),
+		WithAllow("acls"),
+		WithProvenance(ProvenanceTrailing),
+	).Combine(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	values := result.Policy.Find("acls").Value.(*jwcc.Array).Values
+	expected := filepath.Join(dir, "children", "acls.hujson")
+	if path, _ := Provenance(values[1]); path != expected || len(values[1].Comments().Before) != 0 {
+		t.Fatalf("provenance should be a line comment for [%v], got [%v] and [%v]", expected, values[1].Comments().Line, values[1].Comments().Before)
+	}
+
+	_, err = New(WithProvenance("sometimes")).Combine(context.Background())
+	expectedErr := "unsupported provenance style [sometimes], expected one of [none first every trailing]"
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("expected error [%v], got [%v]", expectedErr, err)
+	}
+}
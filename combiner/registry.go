@@ -0,0 +1,104 @@
+package combiner
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// MergeStrategy names a built-in way of merging a section, used to declare
+// sections without writing a SectionHandler.
+type MergeStrategy string
+
+const (
+	// MergeArray appends the values of the section in each child, like acls.
+	MergeArray MergeStrategy = "array"
+	// MergeObject appends the members of the section in each child, like
+	// hosts.
+	MergeObject MergeStrategy = "object"
+	// MergeUnion appends the members of the section in each child and
+	// combines the array values of members with the same name, like groups.
+	MergeUnion MergeStrategy = "union"
+	// MergeScalar sets the section from the first file that has it, and
+	// rejects other files that set it to a different value, like
+	// randomizeClientPort.
+	MergeScalar MergeStrategy = "scalar"
+)
+
+// MergeStrategies are the strategies accepted by Registry.RegisterStrategy.
+var MergeStrategies = []MergeStrategy{MergeArray, MergeObject, MergeUnion, MergeScalar}
+
+// Registry maps section names to the handlers that merge them. A section
+// that is not registered can't be allowed from children, and children that
+// contain it are rejected.
+type Registry map[string]SectionHandler
+
+// NewRegistry returns a Registry with the sections from DefaultSections.
+func NewRegistry() Registry {
+	return Registry(DefaultSections())
+}
+
+// Register adds the handler for the section name, replacing any existing
+// handler, e.g. to support a section added to the policy file format.
+func (r Registry) Register(name string, handler SectionHandler) {
+	r[name] = handler
+}
+
+// RegisterStrategy adds a handler for the section name that merges it with
+// strategy, replacing any existing handler.
+func (r Registry) RegisterStrategy(name string, strategy MergeStrategy) error {
+	handler, err := strategyHandler(strategy)
+	if err != nil {
+		return err
+	}
+	r.Register(name, handler)
+	return nil
+}
+
+// canonical returns the registered name of the section name, matched
+// case-insensitively since policy files accept any casing of section names.
+// If several registered names match, the first in sorted order is used.
+func (r Registry) canonical(name string) (string, bool) {
+	if r[name] != nil {
+		return name, true
+	}
+	for _, registered := range r.Names() {
+		if strings.EqualFold(registered, name) {
+			return registered, true
+		}
+	}
+	return "", false
+}
+
+// CanonicalName returns the registered spelling of the section name, or name
+// itself if no section by that name is registered.
+func (r Registry) CanonicalName(name string) string {
+	if registered, ok := r.canonical(name); ok {
+		return registered
+	}
+	return name
+}
+
+// Names returns the registered section names in sorted order.
+func (r Registry) Names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func strategyHandler(strategy MergeStrategy) (SectionHandler, error) {
+	switch strategy {
+	case MergeArray:
+		return ArrayHandler(), nil
+	case MergeObject:
+		return ObjectHandler(), nil
+	case MergeUnion:
+		return GroupsHandler(), nil
+	case MergeScalar:
+		return ScalarHandler(), nil
+	}
+	return nil, fmt.Errorf("unsupported merge strategy [%s], expected one of %v", strategy, MergeStrategies)
+}
@@ -0,0 +1,116 @@
+package combiner
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	if r["acls"] == nil {
+		t.Fatalf("expected built-in sections to be registered")
+	}
+
+	r.Register("custom", ArrayHandler())
+	err := r.RegisterStrategy("customObject", MergeObject)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if r["custom"] == nil || r["customObject"] == nil {
+		t.Fatalf("expected sections to be registered, got %v", r.Names())
+	}
+
+	err = r.RegisterStrategy("invalid", MergeStrategy("invalid"))
+	if err == nil {
+		t.Fatalf("expected error, got [%v]", err)
+	}
+	if r["invalid"] != nil {
+		t.Fatalf("expected section not to be registered")
+	}
+
+	if NewRegistry()["custom"] != nil {
+		t.Fatalf("expected registries to be independent")
+	}
+}
+
+func TestRegistryCanonical(t *testing.T) {
+	r := NewRegistry()
+	r.Register("Custom", ArrayHandler())
+	r.Register("custom", ArrayHandler())
+
+	// Both registered names match, so the first in sorted order is used
+	// every time rather than whichever the map yields first.
+	for range 20 {
+		if name, ok := r.canonical("CUSTOM"); !ok || name != "Custom" {
+			t.Fatalf("expected [Custom], got [%v] [%v]", name, ok)
+		}
+	}
+	if name, ok := r.canonical("custom"); !ok || name != "custom" {
+		t.Fatalf("expected an exact match to win, got [%v] [%v]", name, ok)
+	}
+	if r.CanonicalName("RandomizeClientPort") != "randomizeClientPort" || r.CanonicalName("unknown") != "unknown" {
+		t.Fatalf("expected registered spellings, got [%v] [%v]", r.CanonicalName("RandomizeClientPort"), r.CanonicalName("unknown"))
+	}
+}
+
+func TestCombineRegisteredSection(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"parent.hujson":          `{"futureSection": ["parent"]}`,
+		"children/a.hujson":      `{"futureSection": ["a"], "counted": {"x": 1}}`,
+		"children/b.hujson":      `{"counted": {"y": 2}}`,
+		"children/c/acls.hujson": `{"acls": []}`,
+	})
+
+	registry := NewRegistry()
+	err := registry.RegisterStrategy("futureSection", MergeArray)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	calls := 0
+	registry.Register("counted", func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member) error {
+		calls++
+		return nil
+	})
+
+	result, err := New(
+		WithParent(filepath.Join(dir, "parent.hujson")),
+		WithChildDir(filepath.Join(dir, "children")),
+		WithAllow("acls", "futureSection", "counted"),
+		WithRegistry(registry),
+	).Combine(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	values := result.Policy.FindKey(ast.TextEqual("futureSection")).Value.(*jwcc.Array).Values
+	if len(values) != 2 {
+		t.Fatalf("futureSection length should be [2], got [%v]", len(values))
+	}
+	if calls != 2 {
+		t.Fatalf("custom handler should be called [2] times, got [%v]", calls)
+	}
+}
+
+func TestCombineConfigDeclaredSection(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"children/" + DefaultConfigFile: `{
+			"sections": {"futureSection": "object"},
+			"allow":    {"**": ["futureSection"]},
+		}`,
+		"children/a.hujson": `{"futureSection": {"a": true}}`,
+		"children/b.hujson": `{"futureSection": {"b": true}, "otherSection": []}`,
+	})
+
+	_, err := New(WithChildDir(filepath.Join(dir, "children"))).Combine(context.Background())
+
+	ds := CollectDiagnostics(err)
+	if len(ds) != 1 || ds[0].Rule != RuleUnsupportedSection || !strings.Contains(ds[0].Message, "otherSection") {
+		t.Fatalf("expected only otherSection to be unsupported, got [%v]", ds)
+	}
+}
@@ -0,0 +1,295 @@
+package combiner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// SectionHandler merges childSection, the section named sectionKey in the
+// child file at childPath, into parent. It returns an error, usually a
+// Diagnostic, if childSection can't be merged.
+type SectionHandler func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member) error
+
+// DefaultSections returns the handlers for the policy sections that can be
+// allowed from children.
+func DefaultSections() map[string]SectionHandler {
+	return map[string]SectionHandler{
+		"acls":            ArrayHandler(),
+		"autoApprovers":   AutoApproversHandler(),
+		"extraDNSRecords": ArrayHandler(),
+		"grants":          ArrayHandler(),
+		"groups":          GroupsHandler(),
+		"ipsets":          ObjectHandler(),
+		"nodeAttrs":       ArrayHandler(), // TODO: need to merge anything?
+		"postures":        ObjectHandler(),
+		"ssh":             ArrayHandler(),
+		"tagOwners":       ObjectHandler(),
+		"tests":           ArrayHandler(),
+		"sshTests":        ArrayHandler(),
+		"hosts":           ObjectHandler(),
+
+		// https://tailscale.com/kb/1337/acl-syntax#network-policy-options
+		"derpMap":             DERPMapHandler(),
+		"disableIPv4":         ScalarHandler(),
+		"OneCGNATRoute":       ScalarHandler(),
+		"randomizeClientPort": ScalarHandler(),
+	}
+}
+
+// getAllowedSections returns the handlers for the allowed sections, keyed by
+// their canonical names. Names are matched case-insensitively. Unsupported
+// names are returned as Diagnostics along with the handlers for the rest.
+func getAllowedSections(allowedAclSections []string, preDefinedAclSections Registry) (map[string]SectionHandler, error) {
+	aclSections := map[string]SectionHandler{}
+	var diags Diagnostics
+	for _, v := range allowedAclSections {
+		name, ok := preDefinedAclSections.canonical(v)
+		if !ok {
+			diags = append(diags, Diagnostic{Severity: SeverityError, Rule: RuleInvalidArgument, Message: fmt.Sprintf("unsupported section [%s] specified in [-allow] flag", v)})
+			continue
+		}
+		aclSections[name] = preDefinedAclSections[name]
+	}
+	return aclSections, diags.err()
+}
+
+// canonicalizeSections renames the sections of doc to their registered
+// names, so that e.g. "RandomizeClientPort" is merged and written as
+// "randomizeClientPort". Spellings of the same section that differ only by
+// case are returned as conflicts.
+func canonicalizeSections(doc *ParsedDocument, sections Registry) Diagnostics {
+	var diags Diagnostics
+	seen := map[string]*jwcc.Member{}
+	for _, m := range doc.Object.Members {
+		key := m.Key.String()
+		name, ok := sections.canonical(key)
+		if !ok {
+			name = key
+		}
+
+		folded := strings.ToLower(name)
+		if first, ok := seen[folded]; ok {
+			diags = append(diags, errorAt(doc.Path, m, RuleConflict, "section [\"%s\"] is also defined as [\"%s\"] at [%s:%d]", key, first.Key.String(), doc.Path, jwcc.ValueLocation(first).First.Line))
+			continue
+		}
+		seen[folded] = m
+
+		if name != key {
+			m.Key = ast.String(name).Quote()
+		}
+	}
+	return diags
+}
+
+// ArrayHandler appends the values of an array section to the parent's.
+func ArrayHandler() SectionHandler {
+	return func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member) error {
+		if childSection == nil {
+			return nil
+		}
+
+		childArr, ok := childSection.Value.(*jwcc.Array)
+		if !ok {
+			return errorAt(childPath, childSection, RuleInvalidFormat, "section [\"%s\"] must be an array", sectionKey)
+		}
+
+		newArr := existingOrNewArray(*parent, sectionKey)
+
+		for _, v := range childArr.Values {
+			pathComment(v, childPath)
+			newArr.Values = append(newArr.Values, v)
+		}
+
+		upsertMember(parent, sectionKey, newArr)
+		return nil
+	}
+}
+
+// ObjectHandler appends the members of an object section to the parent's.
+func ObjectHandler() SectionHandler {
+	return func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member) error {
+		if childSection == nil {
+			return nil
+		}
+
+		childObj, ok := childSection.Value.(*jwcc.Object)
+		if !ok {
+			return errorAt(childPath, childSection, RuleInvalidFormat, "section [\"%s\"] must be an object", sectionKey)
+		}
+
+		newObj := existingOrNewObject(*parent, sectionKey)
+
+		for _, m := range childObj.Members {
+			newObj.Members = append(newObj.Members, copyMember(m, childPath))
+		}
+
+		upsertMember(parent, sectionKey, newObj)
+		return nil
+	}
+}
+
+// GroupsHandler merges groups, combining the members of groups with the same
+// name.
+func GroupsHandler() SectionHandler {
+	// https://tailscale.com/kb/1337/acl-syntax#groups
+	return func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member) error {
+		if childSection == nil {
+			return nil
+		}
+
+		childObj, ok := childSection.Value.(*jwcc.Object)
+		if !ok {
+			return errorAt(childPath, childSection, RuleInvalidFormat, "section [\"%s\"] must be an object", sectionKey)
+		}
+
+		newObj := existingOrNewObject(*parent, sectionKey)
+
+		for _, m := range childObj.Members {
+			existing := newObj.FindKey(ast.TextEqual(m.Key.String()))
+			if existing != nil {
+				existingArr, existingOk := existing.Value.(*jwcc.Array)
+				childArr, childOk := m.Value.(*jwcc.Array)
+				if existingOk && childOk {
+					unionArray(existingArr, childArr, childPath)
+					continue
+				}
+			}
+
+			newObj.Members = append(newObj.Members, copyMember(m, childPath))
+		}
+
+		upsertMember(parent, sectionKey, newObj)
+		return nil
+	}
+}
+
+// unionArray appends the values of src to dst that are not already present,
+// marking them with a provenance comment for srcPath.
+func unionArray(dst *jwcc.Array, src *jwcc.Array, srcPath string) {
+	seen := map[string]bool{}
+	for _, v := range dst.Values {
+		seen[valueKey(v)] = true
+	}
+
+	for _, v := range src.Values {
+		key := valueKey(v)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		pathComment(v, srcPath)
+		dst.Values = append(dst.Values, v)
+	}
+}
+
+// valueKey returns a string identifying v for equality checks, ignoring
+// comments and differences in string quoting.
+func valueKey(v jwcc.Value) string {
+	if t, ok := v.Undecorate().(ast.Text); ok {
+		return t.String()
+	}
+	return v.Undecorate().JSON()
+}
+
+// AutoApproversHandler merges the exitNode and routes of autoApprovers.
+func AutoApproversHandler() SectionHandler {
+	// https://tailscale.com/kb/1337/acl-syntax#auto-approvers-autoapprovers
+	return func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member) error {
+		if childSection == nil {
+			return nil
+		}
+		childSectionObj, ok := childSection.Value.(*jwcc.Object)
+		if !ok {
+			return errorAt(childPath, childSection, RuleInvalidFormat, "section [\"%s\"] must be an object", sectionKey)
+		}
+
+		newObj := existingOrNewObject(*parent, sectionKey)
+
+		childExitNodeProps := childSectionObj.FindKey(ast.TextEqual("exitNode"))
+		arrayFn := ArrayHandler()
+		err := arrayFn("exitNode", parentPath, newObj, childPath, childExitNodeProps)
+		if err != nil {
+			return err
+		}
+
+		childRoutesProps := childSectionObj.FindKey(ast.TextEqual("routes"))
+		objectFn := ObjectHandler()
+		err = objectFn("routes", parentPath, newObj, childPath, childRoutesProps)
+		if err != nil {
+			return err
+		}
+
+		newObj.Sort()
+		upsertMember(parent, sectionKey, newObj)
+		return nil
+	}
+}
+
+func upsertMember[V *jwcc.Object | *jwcc.Array](doc *jwcc.Object, key string, val V) {
+	keyAst := ast.String(key)
+	index := doc.IndexKey(ast.TextEqual(key))
+	if index != -1 {
+		doc.Members[index].Value = jwcc.Value(val)
+	} else {
+		doc.Members = append(doc.Members, &jwcc.Member{Key: keyAst.Quote(), Value: jwcc.Value(val)})
+	}
+}
+
+// addParentPathComments marks every entry of the sections of parentDoc with
+// a provenance comment, like the handlers do for the entries of children.
+func addParentPathComments(parentDoc *ParsedDocument) {
+	for _, parentSection := range parentDoc.Object.Members {
+		switch section := parentSection.Value.(type) {
+		default:
+			pathComment(parentSection, parentDoc.Path)
+		case *jwcc.Array:
+			for _, v := range section.Values {
+				pathComment(v, parentDoc.Path)
+			}
+		case *jwcc.Object:
+			for _, m := range section.Members {
+				pathComment(m, parentDoc.Path)
+			}
+		}
+	}
+}
+
+// copyMember returns a copy of m, with its comments, to add to the combined
+// policy, marked with a provenance comment for path.
+func copyMember(m *jwcc.Member, path string) *jwcc.Member {
+	newMember := &jwcc.Member{Key: m.Key, Value: m.Value}
+	*newMember.Comments() = *m.Comments()
+	pathComment(newMember, path)
+	return newMember
+}
+
+func existingOrNewArray(doc jwcc.Object, key string) *jwcc.Array { // TODO: combine with existingOrNewObject and pass in type?
+	existingSection := doc.FindKey(ast.TextEqual(key))
+	if existingSection == nil {
+		return new(jwcc.Array)
+	}
+	return existingSection.Value.(*jwcc.Array)
+}
+
+func existingOrNewObject(doc jwcc.Object, key string) *jwcc.Object {
+	existingSection := doc.FindKey(ast.TextEqual(key))
+	if existingSection == nil {
+		return new(jwcc.Object)
+	}
+	return existingSection.Value.(*jwcc.Object)
+}
+
+func removeMember(obj *jwcc.Object, key string) []*jwcc.Member {
+	indexKey := obj.IndexKey(ast.TextEqual(key))
+
+	if indexKey == -1 {
+		return obj.Members
+	}
+
+	ret := make([]*jwcc.Member, 0)
+	ret = append(ret, obj.Members[:indexKey]...)
+	return append(ret, obj.Members[indexKey+1:]...)
+}
@@ -0,0 +1,166 @@
+package combiner
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// Source is the position in a policy file an entry of the combined policy
+// was read from.
+type Source struct {
+	File string `json:"file"`
+	// Line and Column are 1-based.
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// SourceMapEntry maps the lines of an array element or object member in the
+// formatted policy to the file it came from.
+type SourceMapEntry struct {
+	// Pointer is the RFC 6901 JSON pointer of the entry, e.g. /acls/0.
+	Pointer string `json:"pointer"`
+	// OutputStart and OutputEnd are the first and last lines of the entry in
+	// the formatted policy, 1-based.
+	OutputStart int `json:"outputStart"`
+	OutputEnd   int `json:"outputEnd"`
+	Source
+}
+
+// SourceMap maps the lines of the formatted policy to the files they came
+// from.
+type SourceMap struct {
+	Entries []SourceMapEntry `json:"entries"`
+}
+
+// Locate returns the innermost entry containing line of the formatted
+// policy. When entries on the same lines are nested, such as a member and
+// its array value, the outer one is returned.
+func (m *SourceMap) Locate(line int) (SourceMapEntry, bool) {
+	var found SourceMapEntry
+	ok := false
+	for _, e := range m.Entries {
+		if line < e.OutputStart || line > e.OutputEnd {
+			continue
+		}
+		if !ok || e.OutputEnd-e.OutputStart < found.OutputEnd-found.OutputStart {
+			found, ok = e, true
+		}
+	}
+	return found, ok
+}
+
+// Source returns the position the entry v of the policy, an array element
+// or object member, was read from. Unlike the provenance comments, it is
+// recorded whatever the provenance style.
+func (r *Result) Source(v jwcc.Value) (Source, bool) {
+	source, ok := r.sources[v]
+	return source, ok
+}
+
+// SourceMap returns the source map of the policy as written by Format.
+// Entries that were not read from a file, such as sections created while
+// merging, are omitted.
+func (r *Result) SourceMap() (*SourceMap, error) {
+	formatted, err := Format(r.Policy)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := jwcc.Parse(bytes.NewReader(formatted))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &SourceMap{Entries: []SourceMapEntry{}}
+	var walk func(v jwcc.Value, out jwcc.Value, pointer string) error
+	add := func(v jwcc.Value, out jwcc.Value, pointer string) error {
+		if source, ok := r.sources[v]; ok {
+			loc := jwcc.ValueLocation(out)
+			m.Entries = append(m.Entries, SourceMapEntry{
+				Pointer:     pointer,
+				OutputStart: loc.First.Line,
+				OutputEnd:   loc.Last.Line,
+				Source:      source,
+			})
+		}
+		return walk(v, out, pointer)
+	}
+	walk = func(v jwcc.Value, out jwcc.Value, pointer string) error {
+		switch t := v.(type) {
+		case *jwcc.Member:
+			return walk(t.Value, out.(*jwcc.Member).Value, pointer)
+		case *jwcc.Array:
+			outArr, ok := out.(*jwcc.Array)
+			if !ok || len(outArr.Values) != len(t.Values) {
+				return errors.New("formatted policy does not match the combined policy")
+			}
+			for i, v := range t.Values {
+				if err := add(v, outArr.Values[i], pointer+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		case *jwcc.Object:
+			outObj, ok := out.(*jwcc.Object)
+			if !ok || len(outObj.Members) != len(t.Members) {
+				return errors.New("formatted policy does not match the combined policy")
+			}
+			for i, m := range t.Members {
+				if err := add(m, outObj.Members[i], pointer+"/"+pointerEscaper.Replace(m.Key.String())); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	err = walk(r.Policy, doc.Value, "")
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// recordSources returns the position every entry of doc was read from,
+// using the provenance comments added while merging, before they are
+// restyled by applyProvenance. Entries without a provenance comment came
+// from the same file as the entry before them or, for the first entry, the
+// entry containing them.
+func recordSources(doc *ParsedDocument) map[jwcc.Value]Source {
+	sources := map[jwcc.Value]Source{}
+
+	var walk func(v jwcc.Value, file string)
+	record := func(v jwcc.Value, file string) string {
+		if path, ok := Provenance(v); ok {
+			file = path
+		}
+		if loc := jwcc.ValueLocation(v); file != "" && loc.First.Line > 0 {
+			sources[v] = Source{File: file, Line: loc.First.Line, Column: loc.First.Column + 1}
+		}
+		walk(v, file)
+		return file
+	}
+	walk = func(v jwcc.Value, file string) {
+		switch t := v.(type) {
+		case *jwcc.Member:
+			walk(t.Value, file)
+		case *jwcc.Array:
+			for _, v := range t.Values {
+				file = record(v, file)
+			}
+		case *jwcc.Object:
+			for _, m := range t.Members {
+				file = record(m, file)
+			}
+		}
+	}
+
+	for _, section := range doc.Object.Members {
+		record(section, doc.Path)
+	}
+	return sources
+}
@@ -0,0 +1,93 @@
+package combiner
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSourceMap(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"parent.hujson": `{
+	"acls": [
+		{"action": "accept", "src": ["*"], "dst": ["*:22"]},
+	],
+}`,
+		"children/acls.hujson": `{
+	// ticket SEC-123
+	"acls": [
+		{
+			"action": "accept",
+			"src": ["a@example.com"],
+			"dst": ["tag:a:*"],
+		},
+	],
+}`,
+	})
+
+	for _, style := range ProvenanceStyles {
+		t.Run(string(style), func(t *testing.T) {
+			result, err := New(
+				WithParent(filepath.Join(dir, "parent.hujson")),
+				WithChildDir(filepath.Join(dir, "children")),
+				WithAllow("acls"),
+				WithProvenance(style),
+			).Combine(context.Background())
+			if err != nil {
+				t.Fatalf("expected no error, got [%v]", err)
+			}
+
+			m, err := result.SourceMap()
+			if err != nil {
+				t.Fatalf("expected no error, got [%v]", err)
+			}
+			formatted, err := Format(result.Policy)
+			if err != nil {
+				t.Fatalf("expected no error, got [%v]", err)
+			}
+			lines := strings.Split(string(formatted), "\n")
+
+			line := 0
+			for i, l := range lines {
+				if strings.Contains(l, "a@example.com") {
+					line = i + 1
+				}
+			}
+
+			entry, ok := m.Locate(line)
+			expected := Source{File: filepath.Join(dir, "children", "acls.hujson"), Line: 6, Column: 4}
+			if !ok || entry.Source != expected || entry.Pointer != "/acls/1/src" {
+				t.Fatalf("line [%d] should be located at [%v] [/acls/1/src], got [%v]", line, expected, entry)
+			}
+			if entry.OutputStart != line || entry.OutputEnd != line {
+				t.Fatalf("entry should be on line [%d], got [%d-%d]", line, entry.OutputStart, entry.OutputEnd)
+			}
+
+			// line 1 is the generated comment.
+			entry, ok = m.Locate(3)
+			if !ok || entry.Pointer != "/acls" || entry.File != filepath.Join(dir, "parent.hujson") {
+				t.Fatalf("line [3] should be located at the parent's [/acls], got [%v]", entry)
+			}
+
+			line = 0
+			for i, l := range lines {
+				if strings.Contains(l, `"*:22"`) {
+					line = i + 1
+				}
+			}
+			entry, _ = m.Locate(line)
+			expected = Source{File: filepath.Join(dir, "parent.hujson"), Line: 3, Column: 38}
+			if entry.Source != expected || entry.Pointer != "/acls/0/dst" {
+				t.Fatalf("line [%d] should be located at [%v] [/acls/0/dst], got [%v]", line, expected, entry)
+			}
+		})
+	}
+}
+
+func TestPointerEscaper(t *testing.T) {
+	got := pointerEscaper.Replace("10.0.0.0/8~a")
+	if got != "10.0.0.0~18~0a" {
+		t.Fatalf("escaped pointer should be [10.0.0.0~18~0a], got [%v]", got)
+	}
+}
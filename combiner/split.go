@@ -0,0 +1,268 @@
+package combiner
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+)
+
+// splitSections are the sections whose entries Split can move to children.
+// Other sections, such as autoApprovers and network policy options, stay in
+// the parent.
+var splitSections = map[string]bool{
+	"acls":            true,
+	"extraDNSRecords": true,
+	"grants":          true,
+	"groups":          true,
+	"hosts":           true,
+	"ipsets":          true,
+	"nodeAttrs":       true,
+	"postures":        true,
+	"ssh":             true,
+	"sshTests":        true,
+	"tagOwners":       true,
+	"tests":           true,
+}
+
+// SplitRule moves the entries of a policy that match Pattern to File.
+type SplitRule struct {
+	// From is set to match Pattern against the file recorded in the
+	// provenance comment of an entry, rather than its tags, groups, users
+	// and other strings.
+	From    bool
+	Pattern *regexp.Regexp
+	// File is the child file relative to the child directory, which may
+	// refer to submatches of Pattern as $1, like regexp.Regexp.Expand.
+	File string
+}
+
+// SplitResult is the outcome of Split.
+type SplitResult struct {
+	// Parent is the policy with the entries moved to children removed.
+	Parent *jwcc.Object
+	// Children maps files, relative to the child directory, to their
+	// policies.
+	Children map[string]*jwcc.Object
+	// Sections are the sections in any child, in sorted order.
+	Sections []string
+}
+
+// LoadSplitRules reads the rules for Split from a file such as:
+//
+//	{
+//		"from": {
+//			"^departments/(.*)$": "$1",
+//		},
+//		"match": {
+//			"^(tag|group):finance": "finance/policy.hujson",
+//		},
+//	}
+//
+// An entry is moved by the first matching rule, in the order they appear in
+// the file.
+func LoadSplitRules(path string) ([]SplitRule, error) {
+	doc, err := Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []SplitRule{}
+	for _, m := range doc.Object.Members {
+		var from bool
+		switch m.Key.String() {
+		case "from":
+			from = true
+		case "match":
+			from = false
+		default:
+			return nil, errorAt(path, m, RuleInvalidConfig, "invalid split rules: unsupported key [%s]", m.Key)
+		}
+
+		obj, ok := m.Value.(*jwcc.Object)
+		if !ok {
+			return nil, errorAt(path, m, RuleInvalidConfig, "invalid split rules: [%s] must be an object", m.Key)
+		}
+		for _, rule := range obj.Members {
+			pattern, err := regexp.Compile(rule.Key.String())
+			if err != nil {
+				return nil, errorAt(path, rule, RuleInvalidConfig, "invalid split rules: [%s.%s] %v", m.Key, rule.Key, err)
+			}
+			file, ok := rule.Value.Undecorate().(ast.Text)
+			if !ok {
+				return nil, errorAt(path, rule, RuleInvalidConfig, "invalid split rules: [%s.%s] must be a file name", m.Key, rule.Key)
+			}
+			rules = append(rules, SplitRule{From: from, Pattern: pattern, File: file.String()})
+		}
+	}
+	return rules, nil
+}
+
+// Split moves the entries of the policy in doc matched by rules to child
+// policies, so that combining the parent and children gives a policy
+// equivalent to doc. Only the entries of splitSections are moved, and
+// provenance comments and the comment marking a combined policy are removed
+// from the results. doc is modified.
+func Split(doc *ParsedDocument, rules []SplitRule) (*SplitResult, error) {
+	result := &SplitResult{Parent: doc.Object, Children: map[string]*jwcc.Object{}}
+	registry := NewRegistry()
+
+	var diags Diagnostics
+	move := func(section string, source string, v jwcc.Value) (*jwcc.Object, bool) {
+		file := splitFile(rules, source, v)
+		if file == "" {
+			return nil, false
+		}
+		if err := checkSplitFile(file); err != nil {
+			diags = append(diags, errorAt(doc.Path, v, RuleInvalidConfig, "invalid split file [%s] for an entry of [%s]: %v", file, section, err))
+			return nil, false
+		}
+		child, ok := result.Children[file]
+		if !ok {
+			child = &jwcc.Object{}
+			result.Children[file] = child
+		}
+		if !slices.Contains(result.Sections, section) {
+			result.Sections = append(result.Sections, section)
+		}
+		return child, true
+	}
+
+	kept := []*jwcc.Member{}
+	for _, section := range doc.Object.Members {
+		name, ok := registry.canonical(section.Key.String())
+		if !ok || !splitSections[name] {
+			kept = append(kept, section)
+			continue
+		}
+
+		source := ""
+		moved := false
+		switch value := section.Value.(type) {
+		case *jwcc.Array:
+			values := []jwcc.Value{}
+			for _, v := range value.Values {
+				if path, ok := Provenance(v); ok {
+					source = path
+				}
+				child, ok := move(name, source, v)
+				if !ok {
+					values = append(values, v)
+					continue
+				}
+				arr := existingOrNewArray(*child, name)
+				arr.Values = append(arr.Values, v)
+				upsertMember(child, name, arr)
+				moved = true
+			}
+			value.Values = values
+			if moved && len(values) == 0 {
+				continue
+			}
+		case *jwcc.Object:
+			members := []*jwcc.Member{}
+			for _, m := range value.Members {
+				if path, ok := Provenance(m); ok {
+					source = path
+				}
+				child, ok := move(name, source, m)
+				if !ok {
+					members = append(members, m)
+					continue
+				}
+				obj := existingOrNewObject(*child, name)
+				obj.Members = append(obj.Members, m)
+				upsertMember(child, name, obj)
+				moved = true
+			}
+			value.Members = members
+			if moved && len(members) == 0 {
+				continue
+			}
+		}
+		kept = append(kept, section)
+	}
+	doc.Object.Members = kept
+
+	if len(diags) > 0 {
+		return nil, diags
+	}
+
+	unmarkGenerated(result.Parent)
+	applyProvenance(result.Parent, ProvenanceNone)
+	for _, child := range result.Children {
+		applyProvenance(child, ProvenanceNone)
+		child.Sort()
+	}
+	slices.Sort(result.Sections)
+	return result, nil
+}
+
+// splitFile returns the file the first matching rule moves v to, where
+// source is the file in the provenance comment of v or the entry before it,
+// or "" if no rule matches.
+func splitFile(rules []SplitRule, source string, v jwcc.Value) string {
+	var selectors []string
+	for _, rule := range rules {
+		if rule.From {
+			if match := rule.Pattern.FindStringSubmatchIndex(source); match != nil {
+				return string(rule.Pattern.ExpandString(nil, rule.File, source, match))
+			}
+			continue
+		}
+
+		if selectors == nil {
+			selectors = entryStrings(v, []string{})
+		}
+		for _, s := range selectors {
+			if match := rule.Pattern.FindStringSubmatchIndex(s); match != nil {
+				return string(rule.Pattern.ExpandString(nil, rule.File, s, match))
+			}
+		}
+	}
+	return ""
+}
+
+// entryStrings appends the strings in v, including the keys of members, to
+// strs.
+func entryStrings(v jwcc.Value, strs []string) []string {
+	switch t := v.(type) {
+	case *jwcc.Member:
+		strs = append(strs, t.Key.String())
+		return entryStrings(t.Value, strs)
+	case *jwcc.Array:
+		for _, v := range t.Values {
+			strs = entryStrings(v, strs)
+		}
+	case *jwcc.Object:
+		for _, m := range t.Members {
+			strs = entryStrings(m, strs)
+		}
+	case *jwcc.Datum:
+		if text, ok := t.Value.(ast.Text); ok {
+			strs = append(strs, text.String())
+		}
+	}
+	return strs
+}
+
+// checkSplitFile returns an error if file can't be read back as a child.
+func checkSplitFile(file string) error {
+	if !filepath.IsLocal(file) {
+		return errors.New("must be a relative path inside the child directory")
+	}
+	if !strings.HasSuffix(file, ".json") && !strings.HasSuffix(file, ".hujson") {
+		return errors.New("must have a .json or .hujson extension")
+	}
+	if filepath.Base(file) == DefaultConfigFile {
+		return errors.New("is the name of the config file")
+	}
+	if filepath.Base(file) == DefaultParentFile {
+		return errors.New("is the name of a parent file")
+	}
+	return nil
+}
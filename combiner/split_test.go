@@ -0,0 +1,145 @@
+package combiner
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func TestLoadSplitRules(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"rules.hujson": `{
+			"match": {"^tag:(\\w+)": "$1.hujson"},
+			"from": {"^departments/(.*)$": "$1"},
+		}`,
+	})
+
+	rules, err := LoadSplitRules(filepath.Join(dir, "rules.hujson"))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("rules length should be [2], got [%v]", len(rules))
+	}
+	if rules[0].From || rules[0].Pattern.String() != `^tag:(\w+)` || rules[0].File != "$1.hujson" {
+		t.Fatalf("first rule should match selectors, got [%+v]", rules[0])
+	}
+	if !rules[1].From || rules[1].Pattern.String() != "^departments/(.*)$" || rules[1].File != "$1" {
+		t.Fatalf("second rule should match provenance, got [%+v]", rules[1])
+	}
+}
+
+func TestLoadSplitRulesInvalid(t *testing.T) {
+	tests := map[string]string{
+		`{"matches": {}}`:            "invalid split rules: unsupported key [matches]",
+		`{"match": []}`:              "invalid split rules: [match] must be an object",
+		`{"match": {"(": "a.json"}}`: "invalid split rules: [match.(] error parsing regexp: missing closing ): `(`",
+		`{"from": {"a": 1}}`:         "invalid split rules: [from.a] must be a file name",
+	}
+	for content, expected := range tests {
+		dir := writeTestFiles(t, map[string]string{"rules.hujson": content})
+		_, err := LoadSplitRules(filepath.Join(dir, "rules.hujson"))
+		if err == nil || !strings.HasSuffix(err.Error(), expected) {
+			t.Fatalf("expected error [%v] for [%v], got [%v]", expected, content, err)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{
+		"acls": [
+			// from ` + "`parent.hujson`" + `
+			{"action": "accept", "src": ["*"], "dst": ["*:22"]},
+			// from ` + "`departments/eng/acls.hujson`" + `
+			{"action": "accept", "src": ["group:eng"], "dst": ["tag:eng:*"]},
+			// ticket SEC-123
+			{"action": "accept", "src": ["group:eng"], "dst": ["tag:db:5432"]},
+		],
+		"tagOwners": {
+			"tag:finance": ["group:finance"],
+			"tag:eng":     ["group:eng"],
+		},
+		"randomizeClientPort": true,
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	rules := []SplitRule{
+		{From: true, Pattern: mustCompile(t, "^departments/(.*)$"), File: "$1"},
+		{Pattern: mustCompile(t, "^tag:(finance)$"), File: "$1.hujson"},
+	}
+	result, err := Split(&ParsedDocument{Path: "policy.hujson", Object: doc.Value.(*jwcc.Object)}, rules)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if strings.Join(result.Sections, ",") != "acls,tagOwners" {
+		t.Fatalf("sections should be [acls,tagOwners], got [%v]", result.Sections)
+	}
+	if len(result.Children) != 2 {
+		t.Fatalf("children length should be [2], got [%v]", len(result.Children))
+	}
+
+	parentAcls := result.Parent.Find("acls").Value.(*jwcc.Array).Values
+	if len(parentAcls) != 1 || len(parentAcls[0].Comments().Before) != 0 {
+		t.Fatalf("parent should keep [1] acl without provenance, got [%v]", parentAcls)
+	}
+	if result.Parent.Find("randomizeClientPort") == nil {
+		t.Fatalf("parent should keep [randomizeClientPort], got [%v]", result.Parent.Members)
+	}
+
+	engAcls := result.Children["eng/acls.hujson"].Find("acls").Value.(*jwcc.Array).Values
+	if len(engAcls) != 2 {
+		t.Fatalf("eng/acls.hujson acls length should be [2], got [%v]", len(engAcls))
+	}
+	if len(engAcls[0].Comments().Before) != 0 {
+		t.Fatalf("provenance comment should be removed, got [%v]", engAcls[0].Comments().Before)
+	}
+	if comments := jwcc.CleanComments(engAcls[1].Comments().Before...); len(comments) != 1 || comments[0] != "ticket SEC-123" {
+		t.Fatalf("comment should be kept, got [%v]", comments)
+	}
+
+	financeOwners := result.Children["finance.hujson"].Find("tagOwners").Value.(*jwcc.Object).Members
+	if len(financeOwners) != 1 || financeOwners[0].Key.String() != "tag:finance" {
+		t.Fatalf("finance.hujson should have [tag:finance], got [%v]", financeOwners)
+	}
+	parentOwners := result.Parent.Find("tagOwners").Value.(*jwcc.Object).Members
+	if len(parentOwners) != 1 || parentOwners[0].Key.String() != "tag:eng" {
+		t.Fatalf("parent should keep [tag:eng], got [%v]", parentOwners)
+	}
+}
+
+func TestSplitInvalidFile(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{
+		"acls": [{"action": "accept", "src": ["*"], "dst": ["tag:a:*"]}],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	for file, expected := range map[string]string{
+		"../a.hujson":            "must be a relative path inside the child directory",
+		"a.txt":                  "must have a .json or .hujson extension",
+		"a/" + DefaultConfigFile: "is the name of the config file",
+	} {
+		rules := []SplitRule{{Pattern: mustCompile(t, "^tag:a"), File: file}}
+		_, err := Split(&ParsedDocument{Path: "policy.hujson", Object: doc.Value.(*jwcc.Object)}, rules)
+		expected = "policy.hujson:2:12: invalid split file [" + file + "] for an entry of [acls]: " + expected
+		if err == nil || err.Error() != expected {
+			t.Fatalf("expected error [%v], got [%v]", expected, err)
+		}
+	}
+}
+
+func mustCompile(t *testing.T, pattern string) *regexp.Regexp {
+	t.Helper()
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	return re
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\n"
+	to := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\no\n"
+
+	expected := `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -12,3 +12,4 @@
+ l
+ m
+ n
++o
+`
+	diff := unifiedDiff("old", "new", []byte(from), []byte(to))
+	if diff != expected {
+		t.Fatalf("diff should be [%v], got [%v]", expected, diff)
+	}
+}
+
+func TestUnifiedDiffMergesNearbyChanges(t *testing.T) {
+	diff := unifiedDiff("old", "new", []byte("a\nb\nc\nd\ne\n"), []byte("A\nb\nc\nd\nE\n"))
+	if strings.Count(diff, "@@ ") != 1 || !strings.Contains(diff, "@@ -1,5 +1,5 @@\n") {
+		t.Fatalf("expected a single hunk, got [%v]", diff)
+	}
+}
+
+func TestUnifiedDiffEqual(t *testing.T) {
+	diff := unifiedDiff("old", "new", []byte("a\nb\n"), []byte("a\nb\n"))
+	if diff != "" {
+		t.Fatalf("diff should be empty, got [%v]", diff)
+	}
+}
+
+func TestUnifiedDiffEmptyFrom(t *testing.T) {
+	expected := "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n"
+	diff := unifiedDiff("old", "new", nil, []byte("a\nb\n"))
+	if diff != expected {
+		t.Fatalf("diff should be [%v], got [%v]", expected, diff)
+	}
+}
+
+func TestUnifiedDiffNoNewlineAtEnd(t *testing.T) {
+	expected := "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"
+	diff := unifiedDiff("old", "new", []byte("a\nb"), []byte("a\nb\n"))
+	if diff != expected {
+		t.Fatalf("diff should be [%v], got [%v]", expected, diff)
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{"acls": [{"action": "accept"}]}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	obj := doc.Value.(*jwcc.Object)
+
+	formatted, err := combiner.Format(obj)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "policy.hujson")
+	err = os.WriteFile(path, formatted, 0644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	var buf bytes.Buffer
+	code := checkFile(&buf, obj, path)
+	if code != 0 || buf.Len() != 0 {
+		t.Fatalf("expected up to date file, got code [%v] and diff [%v]", code, buf.String())
+	}
+
+	err = os.WriteFile(path, []byte(`{"acls": []}`), 0644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	code = checkFile(&buf, obj, path)
+	if code != exitStale {
+		t.Fatalf("exit code should be [%v], got [%v]", exitStale, code)
+	}
+	if !strings.HasPrefix(buf.String(), "--- "+path+"\n+++ "+path+" (combined)\n") {
+		t.Fatalf("expected a diff, got [%v]", buf.String())
+	}
+}
+
+func TestCheckFileMissing(t *testing.T) {
+	var buf bytes.Buffer
+	code := checkFile(&buf, &jwcc.Object{}, filepath.Join(t.TempDir(), "missing.hujson"))
+	if code != exitStale {
+		t.Fatalf("exit code should be [%v], got [%v]", exitStale, code)
+	}
+}
+
+func TestCheckFileOutputFormat(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{"acls": [{"action": "accept"}]}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	obj := doc.Value.(*jwcc.Object)
+
+	path := filepath.Join(t.TempDir(), "policy.json")
+	err = os.WriteFile(path, []byte("{\"acls\":[{\"action\":\"accept\"}]}\n"), 0644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	format := *outputFormat
+	t.Cleanup(func() { *outputFormat = format })
+	*outputFormat = string(combiner.OutputJSONMin)
+
+	var buf bytes.Buffer
+	code := checkFile(&buf, obj, path)
+	if code != 0 || buf.Len() != 0 {
+		t.Fatalf("expected up to date file, got code [%v] and diff [%v]", code, buf.String())
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+// https://tailscale.com/kb/1337/acl-syntax#tests
+type aclTest struct {
+	Src             string         `json:"src"`
+	SrcPostureAttrs map[string]any `json:"srcPostureAttrs"`
+	Proto           string         `json:"proto"`
+	Accept          []string       `json:"accept"`
+	Deny            []string       `json:"deny"`
+}
+
+// https://tailscale.com/kb/1337/acl-syntax#sshtests
+type sshTest struct {
+	Src             stringList     `json:"src"`
+	SrcPostureAttrs map[string]any `json:"srcPostureAttrs"`
+	Dst             []string       `json:"dst"`
+	Accept          []string       `json:"accept"`
+	Check           []string       `json:"check"`
+	Deny            []string       `json:"deny"`
+}
+
+// stringList decodes from either a single string or an array of strings.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(l))
+}
+
+// testResult is the outcome of a single assertion in a test, e.g. one entry
+// in the accept list of a test.
+type testResult struct {
+	// Source is the file that supplied the test, from its provenance
+	// comment.
+	Source string
+	// Location is the file and line the test was read from.
+	Location string
+	Src      string
+	Kind     string
+	Dst      string
+	Passed   bool
+	Message  string
+}
+
+// sourceFunc returns the position an entry of a policy combined in memory
+// was read from, such as Result.Source.
+type sourceFunc func(v jwcc.Value) (combiner.Source, bool)
+
+// testValues calls fn for every value in the array section of doc, along
+// with the file the value came from and the file and line it was read from.
+//
+// policyPath is the file doc was read from, and the file each value came
+// from is taken from its provenance comment. When empty, doc was combined in
+// memory and sources locates the values in the files they came from.
+func testValues(doc *jwcc.Object, section string, policyPath string, sources sourceFunc, fn func(v jwcc.Value, source string, location string)) {
+	member := doc.Find(section)
+	if member == nil {
+		return
+	}
+	arr, ok := member.Value.(*jwcc.Array)
+	if !ok {
+		return
+	}
+
+	source := policyPath
+	for _, v := range arr.Values {
+		if policyPath == "" && sources != nil {
+			s, _ := sources(v)
+			source = s.File
+		} else if path, ok := combiner.Provenance(v); ok {
+			source = path
+		}
+
+		locationPath := policyPath
+		if locationPath == "" {
+			locationPath = source
+		}
+		location := fmt.Sprintf("%s:%d", locationPath, jwcc.ValueLocation(v).First.Line)
+
+		fn(v, source, location)
+	}
+}
+
+// evaluateTests runs every accept and deny assertion in the tests section of
+// doc against its acls and grants.
+func evaluateTests(doc *jwcc.Object, policyPath string, sources sourceFunc) ([]testResult, error) {
+	p, err := loadPolicy(doc, policyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []testResult{}
+	testValues(doc, "tests", policyPath, sources, func(v jwcc.Value, source string, location string) {
+		var test aclTest
+		err := json.Unmarshal([]byte(v.Undecorate().JSON()), &test)
+		if err != nil {
+			results = append(results, testResult{Source: source, Location: location, Message: fmt.Sprintf("invalid test: %v", err)})
+			return
+		}
+		if test.Src == "" {
+			results = append(results, testResult{Source: source, Location: location, Message: "invalid test: missing src"})
+			return
+		}
+
+		check := func(kind string, dst string, want bool) {
+			result := testResult{Source: source, Location: location, Src: test.Src, Kind: kind, Dst: dst}
+			host, portStr, ok := splitHostPort(dst)
+			port, err := strconv.Atoi(portStr)
+			if !ok || err != nil {
+				result.Message = fmt.Sprintf("invalid destination [%s], expected [host:port]", dst)
+				results = append(results, result)
+				return
+			}
+
+			allowed := p.allowsNetwork(test.Src, test.SrcPostureAttrs, test.Proto, host, port)
+			result.Passed = allowed == want
+			if !result.Passed && want {
+				result.Message = "no acl or grant allows access"
+			} else if !result.Passed {
+				result.Message = "access is allowed by an acl or grant"
+			}
+			results = append(results, result)
+		}
+		for _, dst := range test.Accept {
+			check("accept", dst, true)
+		}
+		for _, dst := range test.Deny {
+			check("deny", dst, false)
+		}
+	})
+	return results, nil
+}
+
+// evaluateSSHTests runs every accept, check and deny assertion in the
+// sshTests section of doc against its ssh rules. The first ssh rule that
+// matches a connection decides its action.
+func evaluateSSHTests(doc *jwcc.Object, policyPath string, sources sourceFunc) ([]testResult, error) {
+	p, err := loadPolicy(doc, policyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []testResult{}
+	testValues(doc, "sshTests", policyPath, sources, func(v jwcc.Value, source string, location string) {
+		var test sshTest
+		err := json.Unmarshal([]byte(v.Undecorate().JSON()), &test)
+		if err != nil {
+			results = append(results, testResult{Source: source, Location: location, Message: fmt.Sprintf("invalid ssh test: %v", err)})
+			return
+		}
+		if len(test.Src) == 0 || len(test.Dst) == 0 {
+			results = append(results, testResult{Source: source, Location: location, Message: "invalid ssh test: missing src or dst"})
+			return
+		}
+
+		check := func(src string, kind string, dst string, user string) {
+			result := testResult{Source: source, Location: location, Src: src, Kind: "ssh " + kind, Dst: user + "@" + dst}
+			action := p.sshAction(src, test.SrcPostureAttrs, dst, user)
+			switch kind {
+			case "deny":
+				result.Passed = action == ""
+			default:
+				result.Passed = action == kind
+			}
+			if !result.Passed && action == "" {
+				result.Message = "no ssh rule allows access"
+			} else if !result.Passed {
+				result.Message = fmt.Sprintf("ssh rule with action [%s] allows access", action)
+			}
+			results = append(results, result)
+		}
+		for _, src := range test.Src {
+			for _, dst := range test.Dst {
+				for _, user := range test.Accept {
+					check(src, "accept", dst, user)
+				}
+				for _, user := range test.Check {
+					check(src, "check", dst, user)
+				}
+				for _, user := range test.Deny {
+					check(src, "deny", dst, user)
+				}
+			}
+		}
+	})
+	return results, nil
+}
+
+// reportResults writes failed results, and passed results when verbose, to w
+// and returns the number of failures.
+func reportResults(w io.Writer, results []testResult) int {
+	failed := 0
+	for _, r := range results {
+		status := "PASS"
+		if !r.Passed {
+			status = "FAIL"
+			failed++
+		} else if !*verbose {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s %s", status, r.Location)
+		if !strings.HasPrefix(r.Location, r.Source+":") {
+			fmt.Fprintf(w, " (from `%s`)", r.Source)
+		}
+		if r.Src != "" {
+			fmt.Fprintf(w, ": src [%s] %s [%s]", r.Src, r.Kind, r.Dst)
+		}
+		if r.Message != "" {
+			fmt.Fprintf(w, ": %s", r.Message)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%d passed, %d failed\n", len(results)-failed, failed)
+	return failed
+}
+
+// splitHostPort splits a dst entry like "tag:web:80,443" or "[fd7a::1]:22"
+// into its selector and ports.
+func splitHostPort(s string) (string, string, bool) {
+	i := strings.LastIndex(s, ":")
+	if i == -1 {
+		return "", "", false
+	}
+	return strings.Trim(s[:i], "[]"), s[i+1:], true
+}
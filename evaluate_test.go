@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+const TEST_POLICY = `{
+	"groups": {
+		"group:eng": ["alice@example.com"],
+	},
+	"hosts": {
+		"db": "100.64.0.10",
+		"office": "192.0.2.0/24",
+	},
+	"ipsets": {
+		"ipset:prod": ["100.64.1.0/24", "remove 100.64.1.5"],
+	},
+	"postures": {
+		"posture:latest": ["node:os IN ['macos', 'linux']", "node:tsVersion >= '1.40'"],
+	},
+	"acls": [
+		{"action": "accept", "src": ["group:eng"], "dst": ["tag:web:80,443", "db:5432"]},
+		{"action": "accept", "src": ["office"], "proto": "udp", "dst": ["ipset:prod:1000-2000"]},
+		{"action": "accept", "src": ["bob@example.com"], "srcPosture": ["posture:latest"], "dst": ["autogroup:self:*"]},
+	],
+	"grants": [
+		{"src": ["autogroup:member"], "dst": ["tag:dns"], "ip": ["udp:53"]},
+		{"src": ["tag:ci"], "dst": ["tag:k8s"], "app": {"tailscale.com/cap/kubernetes": []}},
+	],
+	"tests": [
+		// from ` + "`parent`" + `
+		{"src": "alice@example.com", "accept": ["tag:web:443", "db:5432"], "deny": ["tag:web:22"]},
+		// from ` + "`child`" + `
+		{"src": "192.0.2.7", "proto": "udp", "accept": ["100.64.1.4:1500"], "deny": ["100.64.1.5:1500"]},
+		{"src": "bob@example.com", "srcPostureAttrs": {"node:os": "linux", "node:tsVersion": "1.42.1"}, "accept": ["bob@example.com:22"]},
+		{"src": "bob@example.com", "srcPostureAttrs": {"node:os": "windows"}, "accept": ["bob@example.com:22"]},
+		{"src": "carol@example.com", "proto": "udp", "accept": ["tag:dns:53"], "deny": ["tag:k8s:443"]},
+	],
+}`
+
+func TestEvaluateTests(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(TEST_POLICY))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	results, err := evaluateTests(doc.Value.(*jwcc.Object), "policy.hujson", nil)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(results) != 9 {
+		t.Fatalf("results length should be [9], got [%v]", len(results))
+	}
+
+	failed := []testResult{}
+	for _, r := range results {
+		if !r.Passed {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) != 1 {
+		t.Fatalf("failed length should be [1], got [%v]: %v", len(failed), failed)
+	}
+	if failed[0].Src != "bob@example.com" || failed[0].Source != "child" || failed[0].Location != "policy.hujson:30" {
+		t.Fatalf("unexpected failure [%+v]", failed[0])
+	}
+	if results[0].Source != "parent" {
+		t.Fatalf("source should be [parent], got [%v]", results[0].Source)
+	}
+}
+
+func TestEvaluateTestsInvalidDestination(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{
+		"tests": [{"src": "alice@example.com", "accept": ["tag:web"]}],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	results, err := evaluateTests(doc.Value.(*jwcc.Object), "policy.hujson", nil)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if len(results) != 1 || results[0].Passed {
+		t.Fatalf("expected a single failed result, got [%+v]", results)
+	}
+}
+
+func TestEvaluateTestsInvalidPolicy(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{
+		"groups": ["group:eng"],
+		"tests":  [{"src": "alice@example.com", "accept": ["tag:web:80"]}],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	_, err = evaluateTests(doc.Value.(*jwcc.Object), "policy.hujson", nil)
+	ds := combiner.CollectDiagnostics(err)
+	if len(ds) != 1 || ds[0].Path != "policy.hujson" || ds[0].Rule != combiner.RuleInvalidFormat {
+		t.Fatalf("expected an invalid-format diagnostic for [policy.hujson], got [%v]", err)
+	}
+}
+
+func TestReportResults(t *testing.T) {
+	results := []testResult{
+		{Source: "child", Location: "child:3", Src: "a", Kind: "accept", Dst: "b:22", Passed: true},
+		{Source: "child", Location: "policy:10", Src: "a", Kind: "deny", Dst: "c:22", Message: "access is allowed by an acl or grant"},
+	}
+
+	var buf bytes.Buffer
+	failed := reportResults(&buf, results)
+	if failed != 1 {
+		t.Fatalf("failed should be [1], got [%v]", failed)
+	}
+
+	expected := "FAIL policy:10 (from `child`): src [a] deny [c:22]: access is allowed by an acl or grant\n1 passed, 1 failed\n"
+	if buf.String() != expected {
+		t.Fatalf("output should be [%v], got [%v]", expected, buf.String())
+	}
+}
+
+func TestPostureConditionHolds(t *testing.T) {
+	attrs := map[string]any{
+		"node:os":        "macos",
+		"node:tsVersion": "1.40.2",
+	}
+	cases := map[string]bool{
+		"node:os == 'macos'":              true,
+		"node:os != 'macos'":              false,
+		"node:os IN ['linux', 'macos']":   true,
+		"node:os NOT IN ['linux']":        true,
+		"node:tsVersion >= '1.40'":        true,
+		"node:tsVersion < '1.9'":          false,
+		"node:tsReleaseTrack IS SET":      false,
+		"node:tsReleaseTrack NOT SET":     true,
+		"node:tsReleaseTrack == 'stable'": false,
+	}
+	for condition, want := range cases {
+		if got := postureConditionHolds(condition, attrs); got != want {
+			t.Fatalf("condition [%v] should be [%v], got [%v]", condition, want, got)
+		}
+	}
+}
+
+func TestPortsMatch(t *testing.T) {
+	cases := []struct {
+		ports string
+		port  int
+		want  bool
+	}{
+		{"*", 22, true},
+		{"22", 22, true},
+		{"80,443", 443, true},
+		{"80,443", 22, false},
+		{"1000-2000", 1500, true},
+		{"1000-2000", 2001, false},
+	}
+	for _, c := range cases {
+		if got := portsMatch(c.ports, c.port); got != c.want {
+			t.Fatalf("ports [%v] port [%v] should be [%v], got [%v]", c.ports, c.port, c.want, got)
+		}
+	}
+}
+
+func TestEvaluateSSHTests(t *testing.T) {
+	doc, err := jwcc.Parse(strings.NewReader(`{
+		"groups": {
+			"group:eng": ["alice@example.com"],
+		},
+		"ssh": [
+			{"action": "check", "src": ["group:eng"], "dst": ["tag:prod"], "users": ["root"]},
+			{"action": "accept", "src": ["group:eng"], "dst": ["tag:prod", "autogroup:self"], "users": ["autogroup:nonroot"]},
+			{"action": "accept", "src": ["autogroup:member"], "dst": ["tag:dev"], "users": ["localpart:*@example.com"]},
+		],
+		"sshTests": [
+			{"src": "alice@example.com", "dst": ["tag:prod"], "accept": ["ubuntu"], "check": ["root"]},
+			{"src": ["alice@example.com"], "dst": ["alice@example.com"], "accept": ["ubuntu"], "deny": ["root"]},
+			{"src": "bob@example.com", "dst": ["tag:dev"], "accept": ["bob"], "deny": ["alice"]},
+			// from ` + "`child`" + `
+			{"src": "bob@example.com", "dst": ["tag:prod"], "accept": ["ubuntu"]},
+		],
+	}`))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	results, err := evaluateSSHTests(doc.Value.(*jwcc.Object), "", nil)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	if len(results) != 7 {
+		t.Fatalf("results length should be [7], got [%v]", len(results))
+	}
+	for _, r := range results[:6] {
+		if !r.Passed {
+			t.Fatalf("result should pass, got [%+v]", r)
+		}
+	}
+	if results[6].Passed || results[6].Source != "child" || results[6].Location != "child:15" {
+		t.Fatalf("result should fail from [child:15], got [%+v]", results[6])
+	}
+}
+
+func TestRunTestsProvenanceNone(t *testing.T) {
+	dir := t.TempDir()
+	parentPath := filepath.Join(dir, "policy.hujson")
+	childPath := filepath.Join(dir, "children", "tests.hujson")
+	err := os.MkdirAll(filepath.Dir(childPath), 0755)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	err = os.WriteFile(parentPath, []byte(`{"acls": []}`), 0644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	err = os.WriteFile(childPath, []byte("{\n\t\"tests\": [\n\t\t{\"src\": \"alice@example.com\", \"accept\": [\"tag:web:80\"]},\n\t],\n}\n"), 0644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	parent, dirs, allowed, style, stdout := *inParentFile, inChildDirs, allowedAclSections, *provenanceStyle, os.Stdout
+	t.Cleanup(func() {
+		*inParentFile, inChildDirs, allowedAclSections, *provenanceStyle, os.Stdout = parent, dirs, allowed, style, stdout
+	})
+	*inParentFile = parentPath
+	inChildDirs = repeatedFlag{filepath.Dir(childPath)}
+	allowedAclSections = aclSections{"tests"}
+	*provenanceStyle = string(combiner.ProvenanceNone)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	os.Stdout = w
+
+	code := runTests()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if code != 1 || !strings.HasPrefix(string(out), "FAIL "+childPath+":3: ") {
+		t.Fatalf("expected the failure from [%s:3], got [%d] [%s]", childPath, code, out)
+	}
+}
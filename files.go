@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+// hasChildren reports whether child files were given with -d or -files-from.
+func hasChildren() bool {
+	return len(inChildDirs) > 0 || *filesFrom != ""
+}
+
+// readChildFileList reads the child files from -files-from, if set.
+func readChildFileList() error {
+	if *filesFrom == "" {
+		return nil
+	}
+	var err error
+	childFileList, err = readFileList(*filesFrom)
+	if err != nil {
+		return fmt.Errorf("invalid argument -files-from - %v", err)
+	}
+	return nil
+}
+
+// runListFiles prints the child files found with -d and -files-from, whether
+// each one would be merged and why, and returns the exit code.
+func runListFiles() int {
+	err := checkDiagnosticsFormat()
+	if err == nil && !hasChildren() {
+		err = errors.New("missing argument -d - a directory of child files to list, or -files-from, must be provided")
+	}
+	if err == nil {
+		err = readChildFileList()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		usage()
+		return 1
+	}
+
+	files, err := newCombiner().Files(context.Background())
+	if err != nil {
+		reportDiagnostics(nil, err)
+		return 1
+	}
+	writeFileList(os.Stdout, files)
+	return 0
+}
+
+// writeFileList writes a line to w for each of files with whether it would be
+// merged, its path and the reason.
+func writeFileList(w io.Writer, files []combiner.ChildFile) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, f := range files {
+		action := "skip"
+		if f.Merge {
+			action = "merge"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", action, f.Path, f.Reason)
+	}
+	tw.Flush()
+}
+
+// readFileList returns the paths listed in the file at path, or in stdin if
+// path is "-". Paths are separated by NUL characters if there are any, as
+// from git ls-files -z, or by newlines otherwise.
+func readFileList(path string) ([]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sep := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+	paths := []string{}
+	for _, line := range strings.Split(string(data), sep) {
+		line = strings.TrimSuffix(line, "\r")
+		if line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, nil
+}
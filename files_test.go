@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+func TestReadFileList(t *testing.T) {
+	tests := map[string][]string{
+		"a.hujson\nb/c.json\n":              {"a.hujson", "b/c.json"},
+		"a.hujson\r\n\r\nb/c.json":          {"a.hujson", "b/c.json"},
+		"a.hujson\x00b/with\nline.json\x00": {"a.hujson", "b/with\nline.json"},
+		"":                                  {},
+	}
+	for content, expected := range tests {
+		path := filepath.Join(t.TempDir(), "files.txt")
+		err := os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+
+		paths, err := readFileList(path)
+		if err != nil {
+			t.Fatalf("expected no error, got [%v]", err)
+		}
+		if !slices.Equal(paths, expected) {
+			t.Fatalf("paths for [%q] should be [%q], got [%q]", content, expected, paths)
+		}
+	}
+}
+
+func TestWriteFileList(t *testing.T) {
+	var out bytes.Buffer
+	writeFileList(&out, []combiner.ChildFile{
+		{Path: "departments/eng/acls.hujson", Merge: true, Reason: "under [departments]"},
+		{Path: "departments/eng/acls.example.hujson", Reason: "excluded by [**/*.example.hujson]"},
+	})
+	expected := "merge  departments/eng/acls.hujson          under [departments]\n" +
+		"skip   departments/eng/acls.example.hujson  excluded by [**/*.example.hujson]\n"
+	if out.String() != expected {
+		t.Fatalf("expected [%q], got [%q]", expected, out.String())
+	}
+}
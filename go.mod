@@ -6,7 +6,11 @@ toolchain go1.23.10
 
 require github.com/creachadair/jtree v0.0.0-20231211041502-6ba355703cad
 
-require github.com/tailscale/hujson v0.0.0-20250605163823-992244df8c5a
+require (
+	github.com/pelletier/go-toml/v2 v2.4.3
+	github.com/tailscale/hujson v0.0.0-20250605163823-992244df8c5a
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	go4.org/mem v0.0.0-20220726221520-4f986261bf13 // indirect
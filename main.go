@@ -1,51 +1,50 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
-	"github.com/creachadair/jtree/ast"
 	"github.com/creachadair/jtree/jwcc"
-	"github.com/tailscale/hujson"
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
 )
 
+// exitStale is the exit status when -check finds the -o file out of date,
+// distinct from the status for errors.
+const exitStale = 3
+
 var (
 	inParentFile       = flag.String("f", "", "parent file to load from")
-	inChildDir         = flag.String("d", "", "directory to process files from")
-	outFile            = flag.String("o", "", "file to write output to")
+	filesFrom          = flag.String("files-from", "", "file listing child files to process after those in the -d directories, one per line or separated by NUL characters as from git ls-files -z, or - to read stdin")
+	outFile            = flag.String("o", "", "file to write output to, replaced only once the output is complete, or - for stdout (the default)")
+	outputFormat       = flag.String("output-format", string(combiner.OutputHuJSON), fmt.Sprintf("format of the output, one of %v - json and json-min hold the same values as hujson without comments", combiner.OutputFormats))
+	sourceMapFile      = flag.String("sourcemap", "", "file to write a source map of the output to, mapping its lines to the files they came from, or to read with the locate subcommand")
+	splitMapping       = flag.String("mapping", "", "file of rules for moving entries to child files with the split subcommand")
+	checkOutput        = flag.Bool("check", false, "check that the -o file is up to date instead of writing it, printing a diff and exiting with status 3 if it is not")
+	inConfigFile       = flag.String("config", "", "config file with per-directory settings, defaults to "+combiner.DefaultConfigFile+" in each -d directory, or the closest directory above each -files-from file, if it exists")
+	parentFileName     = flag.String("parent-name", combiner.DefaultParentFile, "name of the files in the -d directory tree that define sections for their directory and may narrow the sections allowed beneath it")
+	diagnosticsFormat  = flag.String("diagnostics-format", "text", "format of errors and warnings written to stderr, one of "+strings.Join(combiner.DiagnosticsFormats, ", "))
+	provenanceStyle    = flag.String("provenance", string(combiner.ProvenanceFirst), fmt.Sprintf("style of the comments recording the file each entry came from, one of %v", combiner.ProvenanceStyles))
+	mergeOrder         = flag.String("order", string(combiner.OrderLexical), fmt.Sprintf("order child files are merged in, one of %v", combiner.MergeOrders))
+	listFiles          = flag.Bool("list-files", false, "print the child files that would be merged, and why each file is or isn't, without merging them")
 	verbose            = flag.Bool("v", false, "enable verbose logging")
 	allowedAclSections aclSections
-
-	// TODO: anything special to do with top-level properties - https://tailscale.com/kb/1337/acl-syntax#network-policy-options ?
-	// TODO: worry about casing? mainly -allow arg not matching casing?
-	preDefinedAclSections = map[string]SectionHandler{
-		"acls":            handleArray(),
-		"autoApprovers":   handleAutoApprovers(),
-		"extraDNSRecords": handleArray(),
-		"grants":          handleArray(),
-		"groups":          handleObject(),
-		"ipsets":          handleObject(),
-		"nodeAttrs":       handleArray(), // TODO: need to merge anything?
-		"postures":        handleObject(),
-		"ssh":             handleArray(),
-		"tagOwners":       handleObject(),
-		"tests":           handleArray(),
-		"sshTests":        handleArray(),
-		"hosts":           handleObject(),
-	}
+	inChildDirs        repeatedFlag
+	includeGlobs       repeatedFlag
+	excludeGlobs       repeatedFlag
+	childFileList      []string
+	onDuplicate        = combiner.DefaultDuplicatePolicies()
 )
 
-type ParsedDocument struct {
-	Path   string
-	Object *jwcc.Object
-}
 type aclSections []string
 
 func (i *aclSections) String() string {
@@ -60,27 +59,118 @@ func (i *aclSections) Set(value string) error {
 	return nil
 }
 
+// repeatedFlag is a flag that may be repeated.
+type repeatedFlag []string
+
+func (l *repeatedFlag) String() string {
+	return fmt.Sprintf("%s", *l)
+}
+
+func (l *repeatedFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func usage() {
 	fmt.Fprintf(os.Stderr, "usage: tailscale-acl-combiner [flags]\n")
+	fmt.Fprintf(os.Stderr, "       tailscale-acl-combiner test -f <policy-file> [flags]\n")
+	fmt.Fprintf(os.Stderr, "       tailscale-acl-combiner diff [flags] <old-policy-file> [<new-policy-file>]\n")
+	fmt.Fprintf(os.Stderr, "       tailscale-acl-combiner locate -sourcemap <source-map-file> <line>\n")
+	fmt.Fprintf(os.Stderr, "       tailscale-acl-combiner split -f <policy-file> -o <parent-file> -d <directory> -mapping <mapping-file>\n")
 	flag.PrintDefaults()
 }
 
 func checkArgs() error {
+	err := checkDiagnosticsFormat()
+	if err != nil {
+		return err
+	}
 	if *inParentFile == "" {
 		return errors.New("missing argument -f - a parent file must be provided")
 	}
-	if *inChildDir == "" {
-		return errors.New("missing argument -d - a directory of child files to process must be provided")
+	if !hasChildren() {
+		return errors.New("missing argument -d - a directory of child files to process, or -files-from, must be provided")
+	}
+	err = readChildFileList()
+	if err != nil {
+		return err
 	}
-	if len(allowedAclSections) == 0 {
+	if len(allowedAclSections) == 0 && newCombiner().ConfigPath() == "" {
 		return errors.New("missing argument -allow - a list of acl sections to allow from children must be provided - e.g. -allow=acls,ssh")
 	}
+	if !slices.Contains(combiner.ProvenanceStyles, combiner.ProvenanceStyle(*provenanceStyle)) {
+		return fmt.Errorf("invalid argument -provenance - must be one of %v", combiner.ProvenanceStyles)
+	}
+	if !slices.Contains(combiner.MergeOrders, combiner.MergeOrder(*mergeOrder)) {
+		return fmt.Errorf("invalid argument -order - must be one of %v", combiner.MergeOrders)
+	}
+	if !slices.Contains(combiner.OutputFormats, combiner.OutputFormat(*outputFormat)) {
+		return fmt.Errorf("invalid argument -output-format - must be one of %v", combiner.OutputFormats)
+	}
+	if *sourceMapFile != "" && combiner.OutputFormat(*outputFormat) != combiner.OutputHuJSON {
+		return fmt.Errorf("invalid argument -sourcemap - a source map can only be written with -output-format %s", combiner.OutputHuJSON)
+	}
+	if *checkOutput && (*outFile == "" || *outFile == "-") {
+		return errors.New("missing argument -o - a file to check must be provided with -check")
+	}
+	return nil
+}
+
+func checkDiagnosticsFormat() error {
+	if !slices.Contains(combiner.DiagnosticsFormats, *diagnosticsFormat) {
+		return fmt.Errorf("invalid argument -diagnostics-format - must be one of %v", combiner.DiagnosticsFormats)
+	}
 	return nil
 }
 
+// reportDiagnostics writes the warnings in result, if any, and the
+// diagnostics in err to stderr in the format from -diagnostics-format. The
+// json and sarif formats are always written so tools reading them get a
+// valid document.
+func reportDiagnostics(result *combiner.Result, err error) {
+	var ds combiner.Diagnostics
+	if result != nil {
+		ds = append(ds, result.Warnings...)
+	}
+	ds = append(ds, combiner.CollectDiagnostics(err)...)
+	if len(ds) == 0 && *diagnosticsFormat != "json" && *diagnosticsFormat != "sarif" {
+		return
+	}
+	printErr := combiner.WriteDiagnostics(os.Stderr, *diagnosticsFormat, ds)
+	if printErr != nil {
+		log.Fatal(printErr)
+	}
+}
+
 func main() {
+	flag.Var(&inChildDirs, "d", "directory to process files from, may be repeated")
+	flag.Var(&includeGlobs, "include", "only merge child files matching this glob, relative to their -d directory, may be repeated")
+	flag.Var(&excludeGlobs, "exclude", "skip child files matching this glob, relative to their -d directory, may be repeated")
 	flag.Var(&allowedAclSections, "allow", "acl sections to allow from children")
+	flag.Var(onDuplicate, "duplicates", "policy for keys defined more than once in an object section, one of [error, warn, union, parent-wins] - e.g. -duplicates=tagOwners=parent-wins,hosts=warn")
+	flag.Usage = usage
+
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		flag.CommandLine.Parse(os.Args[2:])
+		os.Exit(runTests())
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		flag.CommandLine.Parse(os.Args[2:])
+		os.Exit(runDiff())
+	}
+	if len(os.Args) > 1 && os.Args[1] == "locate" {
+		flag.CommandLine.Parse(os.Args[2:])
+		os.Exit(runLocate())
+	}
+	if len(os.Args) > 1 && os.Args[1] == "split" {
+		flag.CommandLine.Parse(os.Args[2:])
+		os.Exit(runSplit())
+	}
+
 	flag.Parse()
+	if *listFiles {
+		os.Exit(runListFiles())
+	}
 	argsErr := checkArgs()
 	if argsErr != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", argsErr)
@@ -88,302 +178,249 @@ func main() {
 		os.Exit(1)
 	}
 
-	var parentDoc *ParsedDocument
-	var err error
-	if *inParentFile != "" {
-		parentDoc, err = parse(*inParentFile)
-		if err != nil {
-			log.Fatal(err)
-		}
-	} else {
-		parentDoc = &ParsedDocument{
-			Object: &jwcc.Object{
-				Members: make([]*jwcc.Member, 0),
-			},
-		}
-	}
-
-	childDocs, err := gatherChildren(*inChildDir)
+	result, err := newCombiner().Combine(context.Background())
+	reportDiagnostics(result, err)
 	if err != nil {
-		log.Fatal(err)
+		os.Exit(1)
 	}
 
-	aclSections, err := getAllowedSections(allowedAclSections, preDefinedAclSections)
-	if err != nil {
-		log.Fatal(err)
+	if *checkOutput {
+		os.Exit(checkFile(os.Stdout, result.Policy, *outFile))
 	}
 
-	err = mergeDocs(aclSections, parentDoc, childDocs)
+	err = outputFile(result.Policy)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
 	}
 
-	outputFile(parentDoc.Object)
-}
-
-func getAllowedSections(allowedAclSections []string, preDefinedAclSections map[string]SectionHandler) (map[string]SectionHandler, error) {
-	aclSections := map[string]SectionHandler{}
-	for _, v := range allowedAclSections {
-		if preDefinedAclSections[v] == nil {
-			return nil, fmt.Errorf("unsupported section [%s] specified in [-allow] flag", v)
+	if *sourceMapFile != "" {
+		err = writeSourceMap(result, *sourceMapFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
 		}
-		aclSections[v] = preDefinedAclSections[v]
 	}
-	logVerbose("allowing ACL sections [%v]\n", aclSections)
-	return aclSections, nil
 }
 
-type SectionHandler func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member)
-
-func handleArray() SectionHandler {
-	return func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member) {
-		if childSection == nil {
-			return
-		}
-
-		newArr := existingOrNewArray(*parent, sectionKey)
-
-		pathCommentAlreadyAdded := false
-		for _, v := range childSection.Value.(*jwcc.Array).Values {
-			newArr.Values = append(newArr.Values, v)
-
-			if !pathCommentAlreadyAdded {
-				pathComment(v, childPath)
-				pathCommentAlreadyAdded = true
-			}
-		}
-
-		upsertMember(parent, sectionKey, newArr)
+// newCombiner returns a Combiner configured from the command line flags.
+func newCombiner() *combiner.Combiner {
+	opts := []combiner.Option{
+		combiner.WithParent(*inParentFile),
+		combiner.WithChildFiles(childFileList...),
+		combiner.WithInclude(includeGlobs...),
+		combiner.WithExclude(excludeGlobs...),
+		combiner.WithConfig(*inConfigFile),
+		combiner.WithParentFileName(*parentFileName),
+		combiner.WithAllow(allowedAclSections...),
+		combiner.WithDuplicates(onDuplicate),
+		combiner.WithProvenance(combiner.ProvenanceStyle(*provenanceStyle)),
+		combiner.WithOrder(combiner.MergeOrder(*mergeOrder)),
 	}
+	for _, dir := range inChildDirs {
+		opts = append(opts, combiner.WithChildDir(dir))
+	}
+	if *verbose {
+		opts = append(opts, combiner.WithLogger(log.New(os.Stderr, "", 0)))
+	}
+	return combiner.New(opts...)
 }
 
-func handleObject() SectionHandler {
-	return func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member) {
-		if childSection == nil {
-			return
-		}
+// checkFile compares doc, formatted as -output-format, to the file at path,
+// writing a unified diff to w if they differ, and returns the exit code.
+func checkFile(w io.Writer, doc *jwcc.Object, path string) int {
+	formatted, err := combiner.FormatAs(doc, combiner.OutputFormat(*outputFormat))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
 
-		newObj := existingOrNewObject(*parent, sectionKey)
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
 
-		pathCommentAlreadyAdded := false
-		for _, m := range childSection.Value.(*jwcc.Object).Members {
-			newMember := &jwcc.Member{Key: m.Key, Value: m.Value}
-			newObj.Members = append(newObj.Members, newMember)
+	if bytes.Equal(existing, formatted) {
+		logVerbose("[%s] is up to date\n", path)
+		return 0
+	}
 
-			if !pathCommentAlreadyAdded {
-				pathComment(newMember, childPath)
-				pathCommentAlreadyAdded = true
-			}
-		}
+	fmt.Fprintf(os.Stderr, "[%s] is out of date, run tailscale-acl-combiner without -check to update it\n", path)
+	fmt.Fprint(w, unifiedDiff(path, path+" (combined)", existing, formatted))
+	return exitStale
+}
 
-		upsertMember(parent, sectionKey, newObj)
+// runTests evaluates the tests and sshTests in the policy from -f, combining it with the
+// children from -d first if provided, and returns the exit code.
+func runTests() int {
+	formatErr := checkDiagnosticsFormat()
+	if formatErr != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", formatErr)
+		usage()
+		return 1
+	}
+	if *inParentFile == "" {
+		fmt.Fprintf(os.Stderr, "missing argument -f - a policy file must be provided\n")
+		usage()
+		return 1
 	}
-}
 
-func handleAutoApprovers() SectionHandler {
-	// https://tailscale.com/kb/1337/acl-syntax#auto-approvers-autoapprovers
-	return func(sectionKey string, parentPath string, parent *jwcc.Object, childPath string, childSection *jwcc.Member) {
-		if childSection == nil {
-			return
+	var doc *jwcc.Object
+	var sources sourceFunc
+	policyPath := *inParentFile
+	if hasChildren() {
+		argsErr := checkArgs()
+		if argsErr != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", argsErr)
+			usage()
+			return 1
 		}
-		newObj := existingOrNewObject(*parent, sectionKey)
-
-		childSectionObj := childSection.Value.(*jwcc.Object)
-
-		childExitNodeProps := childSectionObj.FindKey(ast.TextEqual("exitNode"))
-		arrayFn := handleArray()
-		arrayFn("exitNode", parentPath, newObj, childPath, childExitNodeProps)
+		result, err := newCombiner().Combine(context.Background())
+		reportDiagnostics(result, err)
+		if err != nil {
+			return 1
+		}
+		doc = result.Policy
+		sources = result.Source
+		policyPath = ""
+	} else {
+		parsed, err := combiner.Parse(*inParentFile)
+		reportDiagnostics(nil, err)
+		if err != nil {
+			return 1
+		}
+		doc = parsed.Object
+	}
 
-		childRoutesProps := childSectionObj.FindKey(ast.TextEqual("routes"))
-		objectFn := handleObject()
-		objectFn("routes", parentPath, newObj, childPath, childRoutesProps)
+	results, err := evaluateTests(doc, policyPath, sources)
+	if err != nil {
+		reportDiagnostics(nil, err)
+		return 1
+	}
 
-		newObj.Sort()
-		upsertMember(parent, sectionKey, newObj)
+	sshResults, err := evaluateSSHTests(doc, policyPath, sources)
+	if err != nil {
+		reportDiagnostics(nil, err)
+		return 1
 	}
-}
+	results = append(results, sshResults...)
 
-func upsertMember[V *jwcc.Object | *jwcc.Array](doc *jwcc.Object, key string, val V) {
-	keyAst := ast.String(key)
-	index := doc.IndexKey(ast.TextEqual(key))
-	if index != -1 {
-		doc.Members[index] = &jwcc.Member{Key: keyAst.Quote(), Value: jwcc.Value(val)}
-	} else {
-		doc.Members = append(doc.Members, &jwcc.Member{Key: keyAst.Quote(), Value: jwcc.Value(val)})
+	if reportResults(os.Stdout, results) > 0 {
+		return 1
 	}
+	return 0
 }
 
-func pathComment(val jwcc.Value, path string) {
-	// TODO: preserve existing comments
-	val.Comments().Before = []string{fmt.Sprintf("from `%s`", path)}
-}
+// runDiff reports the entries added, removed and modified in each section
+// from the first policy file argument to the second, or to the policy
+// combined from -f and -d if provided, and returns the exit code.
+func runDiff() int {
+	formatErr := checkDiagnosticsFormat()
+	if formatErr != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", formatErr)
+		usage()
+		return 1
+	}
 
-func addParentPathComments(parentDoc *ParsedDocument) error {
-	for _, parentSection := range parentDoc.Object.Members {
-		logVerbose("adding parent path comment to [%s]\n", parentSection.Key)
-		switch parentSection.Value.(type) {
-		default:
-			pathComment(parentSection, parentDoc.Path)
-		case *jwcc.Array:
-			if len(parentSection.Value.(*jwcc.Array).Values) != 0 {
-				pathComment(parentSection.Value.(*jwcc.Array).Values[0], parentDoc.Path)
-			}
-		case *jwcc.Object:
-			if len(parentSection.Value.(*jwcc.Object).Members) != 0 {
-				pathComment(parentSection.Value.(*jwcc.Object).Members[0], parentDoc.Path)
-			}
-		}
+	wantArgs := 2
+	if hasChildren() {
+		wantArgs = 1
+	}
+	if flag.NArg() != wantArgs {
+		fmt.Fprintf(os.Stderr, "expected %d policy files, got %d\n", wantArgs, flag.NArg())
+		usage()
+		return 1
 	}
-	return nil
-}
 
-func mergeDocs(sections map[string]SectionHandler, parentDoc *ParsedDocument, childDocs []*ParsedDocument) error {
-	err := addParentPathComments(parentDoc)
+	oldDoc, err := combiner.Parse(flag.Arg(0))
 	if err != nil {
-		log.Fatal(err)
+		reportDiagnostics(nil, err)
+		return 1
 	}
 
-	for _, child := range childDocs {
-		if child.Path == parentDoc.Path {
-			logVerbose("skipping [%s], same doc as parent\n", child.Path)
-			continue
+	var newDoc *jwcc.Object
+	if hasChildren() {
+		argsErr := checkArgs()
+		if argsErr != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", argsErr)
+			usage()
+			return 1
 		}
-
-		for sectionKey, handlerFn := range sections {
-			childSection := child.Object.Find(sectionKey)
-			if childSection == nil {
-				continue
-			}
-
-			handlerFn(sectionKey, parentDoc.Path, parentDoc.Object, child.Path, childSection)
-			child.Object.Members = removeMember(child.Object, sectionKey)
+		result, err := newCombiner().Combine(context.Background())
+		reportDiagnostics(result, err)
+		if err != nil {
+			return 1
 		}
-
-		for _, remainingSection := range child.Object.Members {
-			return fmt.Errorf("unsupported section [\"%s\"] in file [%s]", remainingSection.Key, child.Path)
+		newDoc = result.Policy
+	} else {
+		parsed, err := combiner.Parse(flag.Arg(1))
+		reportDiagnostics(nil, err)
+		if err != nil {
+			return 1
 		}
+		newDoc = parsed.Object
 	}
 
-	parentDoc.Object.Sort()
-
-	return nil
-}
-
-func gatherChildren(path string) ([]*ParsedDocument, error) {
-	children := []*ParsedDocument{}
-
-	logVerbose(fmt.Sprintf("walking path [%v]...\n", path))
-	err := filepath.WalkDir(
-		*inChildDir,
-		func(path string, info fs.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-
-			if info.IsDir() {
-				return nil
-			}
-
-			if !strings.HasSuffix(path, ".json") && !strings.HasSuffix(path, ".hujson") {
-				return nil
-			}
-
-			doc, err := parse(path)
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			children = append(children, doc)
-			return nil
-		},
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	return children, nil
+	reportChanges(os.Stdout, diffPoliciesInOrder(oldDoc.Object, newDoc))
+	return 0
 }
 
+// outputFile writes doc, formatted as -output-format, to the -o file, or to
+// stdout if -o is empty or "-".
 func outputFile(doc *jwcc.Object) error {
-	var sb strings.Builder
-	err := jwcc.Format(&sb, doc)
-	if err != nil {
-		return err
-	}
-
-	formatted, err := hujson.Format([]byte(sb.String()))
+	formatted, err := combiner.FormatAs(doc, combiner.OutputFormat(*outputFormat))
 	if err != nil {
 		return err
 	}
 
-	if *outFile != "" {
-		f, err := os.Create(*outFile)
+	if *outFile == "" || *outFile == "-" {
+		_, err = os.Stdout.Write(formatted)
 		if err != nil {
-			return err
+			return fmt.Errorf("error writing to stdout: %w", err)
 		}
-		defer f.Close()
-
-		w := bufio.NewWriter(f)
-		w.Write(formatted)
-		w.Flush()
-	} else {
-		fmt.Print(string(formatted))
+		return nil
 	}
-	return nil
+	return writeFileAtomic(*outFile, formatted)
 }
 
-func parse(path string) (*ParsedDocument, error) {
-	logVerbose(fmt.Sprintf("parsing [%v]...\n", path))
-
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// writeFileAtomic replaces the file at path with data, by writing a temporary
+// file in the same directory and renaming it, so that path is left as it was
+// if writing fails. An existing file keeps its permissions, and a symlink is
+// written through to its target.
+func writeFileAtomic(path string, data []byte) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	mode := fs.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
 	}
-	defer f.Close()
 
-	doc, err := jwcc.Parse(f)
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
 	if err != nil {
-		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		return fmt.Errorf("error writing [%s]: %w", path, err)
 	}
-
-	root, ok := doc.Value.(*jwcc.Object)
-	if !ok {
-		return nil, fmt.Errorf("invalid file format: document root is [%T], expected [object]", doc.Value)
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(mode)
 	}
-
-	return &ParsedDocument{Path: path, Object: root}, nil
-}
-
-func existingOrNewArray(doc jwcc.Object, key string) *jwcc.Array { // TODO: combine with existingOrNewObject and pass in type?
-	existingSection := doc.FindKey(ast.TextEqual(key))
-	if existingSection == nil {
-		logVerbose("section [%s] not found in parent doc, creating new array\n", key)
-		return new(jwcc.Array)
+	if err == nil {
+		err = f.Sync()
 	}
-	logVerbose("section [%s] found in parent doc, re-using array\n", key)
-	return existingSection.Value.(*jwcc.Array)
-}
-
-func existingOrNewObject(doc jwcc.Object, key string) *jwcc.Object {
-	existingSection := doc.FindKey(ast.TextEqual(key))
-	if existingSection == nil {
-		logVerbose("section [%s] not found in parent doc, creating new object\n", key)
-		return new(jwcc.Object)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
 	}
-	logVerbose("section [%s] found in parent doc, re-using object\n", key)
-	return existingSection.Value.(*jwcc.Object)
-}
-
-func removeMember(obj *jwcc.Object, key string) []*jwcc.Member {
-	indexKey := obj.IndexKey(ast.TextEqual(key))
-
-	if indexKey == -1 {
-		return obj.Members
+	if err == nil {
+		err = os.Rename(tmp, path)
 	}
-
-	ret := make([]*jwcc.Member, 0)
-	ret = append(ret, obj.Members[:indexKey]...)
-	return append(ret, obj.Members[indexKey+1:]...)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing [%s]: %w", path, err)
+	}
+	return nil
 }
 
 func logVerbose(message string, a ...any) {
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+// policy is the subset of a policy file needed to evaluate tests offline.
+// Field names are matched case-insensitively when decoding.
+type policy struct {
+	Groups            map[string][]string `json:"groups"`
+	Hosts             map[string]string   `json:"hosts"`
+	IPSets            map[string][]string `json:"ipsets"`
+	Postures          map[string][]string `json:"postures"`
+	DefaultSrcPosture []string            `json:"defaultSrcPosture"`
+	ACLs              []aclRule           `json:"acls"`
+	Grants            []grantRule         `json:"grants"`
+	SSH               []sshRule           `json:"ssh"`
+}
+
+// https://tailscale.com/kb/1337/acl-syntax#acls
+type aclRule struct {
+	Action     string   `json:"action"`
+	Src        []string `json:"src"`
+	Proto      string   `json:"proto"`
+	Dst        []string `json:"dst"`
+	SrcPosture []string `json:"srcPosture"`
+}
+
+// https://tailscale.com/kb/1324/grants
+type grantRule struct {
+	Src        []string `json:"src"`
+	Dst        []string `json:"dst"`
+	IP         []string `json:"ip"`
+	SrcPosture []string `json:"srcPosture"`
+}
+
+// https://tailscale.com/kb/1337/acl-syntax#tailscale-ssh
+type sshRule struct {
+	Action     string   `json:"action"`
+	Src        []string `json:"src"`
+	Dst        []string `json:"dst"`
+	Users      []string `json:"users"`
+	SrcPosture []string `json:"srcPosture"`
+}
+
+// loadPolicy decodes the sections of doc, read from policyPath, needed to
+// evaluate tests.
+func loadPolicy(doc *jwcc.Object, policyPath string) (*policy, error) {
+	p := &policy{}
+	err := json.Unmarshal([]byte(doc.Undecorate().JSON()), p)
+	if err != nil {
+		return nil, combiner.Diagnostic{Path: policyPath, Severity: combiner.SeverityError, Rule: combiner.RuleInvalidFormat, Message: fmt.Sprintf("error loading policy: %v", err)}
+	}
+	return p, nil
+}
+
+// allowsNetwork reports whether any acl or grant allows src, a user, group,
+// tag, host or IP address, to reach port on dst using proto.
+func (p *policy) allowsNetwork(src string, attrs map[string]any, proto string, dst string, port int) bool {
+	for _, rule := range p.ACLs {
+		if rule.Action != "accept" {
+			continue
+		}
+		if !p.matchesAny(rule.Src, src, "") || !p.postureAllows(rule.SrcPosture, attrs) {
+			continue
+		}
+		if !protoMatches(rule.Proto, proto) {
+			continue
+		}
+		for _, d := range rule.Dst {
+			selector, ports, ok := splitHostPort(d)
+			if !ok {
+				continue
+			}
+			if p.matches(selector, dst, src) && portsMatch(ports, port) {
+				return true
+			}
+		}
+	}
+
+	for _, grant := range p.Grants {
+		if !p.matchesAny(grant.Src, src, "") || !p.postureAllows(grant.SrcPosture, attrs) {
+			continue
+		}
+		if !p.matchesAny(grant.Dst, dst, src) {
+			continue
+		}
+		for _, ip := range grant.IP {
+			if ip == "*" {
+				return true
+			}
+			grantProto, ports, ok := strings.Cut(ip, ":")
+			if !ok {
+				grantProto, ports = "", ip
+			}
+			if protoMatches(grantProto, proto) && portsMatch(ports, port) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sshAction returns the action of the first ssh rule that allows src to
+// connect to dst as user, or "" if no rule does.
+func (p *policy) sshAction(src string, attrs map[string]any, dst string, user string) string {
+	for _, rule := range p.SSH {
+		if !p.matchesAny(rule.Src, src, "") || !p.postureAllows(rule.SrcPosture, attrs) {
+			continue
+		}
+		if !p.matchesAny(rule.Dst, dst, src) {
+			continue
+		}
+		for _, u := range rule.Users {
+			if sshUserMatches(u, user, src) {
+				return rule.Action
+			}
+		}
+	}
+	return ""
+}
+
+// sshUserMatches reports whether the local user on the destination matches
+// selector, an entry in the users of an ssh rule.
+func sshUserMatches(selector string, user string, src string) bool {
+	switch {
+	case selector == user:
+		return true
+	case selector == "autogroup:nonroot":
+		return user != "root"
+	case strings.HasPrefix(selector, "localpart:"):
+		// localpart:*@example.com matches the local part of a source user
+		// in example.com.
+		domain, ok := strings.CutPrefix(strings.TrimPrefix(selector, "localpart:"), "*@")
+		localPart, srcDomain, isEmail := strings.Cut(src, "@")
+		return ok && isEmail && srcDomain == domain && localPart == user
+	}
+	return false
+}
+
+func (p *policy) matchesAny(selectors []string, entity string, self string) bool {
+	for _, s := range selectors {
+		if p.matches(s, entity, self) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether entity matches selector, a src or dst entry from a
+// rule. self is the source of the connection, used for autogroup:self.
+//
+// Autogroups that depend on tailnet state, such as autogroup:admin, never
+// match since they cannot be resolved offline.
+func (p *policy) matches(selector string, entity string, self string) bool {
+	if selector == "*" || selector == entity {
+		return true
+	}
+
+	switch {
+	case strings.HasPrefix(selector, "group:"):
+		for _, member := range p.Groups[selector] {
+			if member == entity {
+				return true
+			}
+		}
+		return false
+	case strings.HasPrefix(selector, "tag:"):
+		return false
+	case selector == "autogroup:member":
+		return isUser(entity) || strings.HasPrefix(entity, "group:")
+	case selector == "autogroup:tagged":
+		return strings.HasPrefix(entity, "tag:")
+	case selector == "autogroup:self":
+		return isUser(self) && entity == self
+	case selector == "autogroup:internet":
+		addr, ok := p.addr(entity)
+		return ok && !isTailnetAddr(addr)
+	case selector == "autogroup:danger-all":
+		return true
+	case strings.HasPrefix(selector, "autogroup:"):
+		return false
+	case strings.Contains(selector, "@"):
+		user := strings.TrimPrefix(selector, "user:")
+		if domain, ok := strings.CutPrefix(user, "*@"); ok {
+			return isUser(entity) && strings.HasSuffix(entity, "@"+domain)
+		}
+		return user == entity
+	}
+
+	addr, ok := p.addr(entity)
+	if !ok {
+		return false
+	}
+	return p.addrMatcher(selector, 0)(addr)
+}
+
+func isUser(entity string) bool {
+	return strings.Contains(entity, "@") && !strings.Contains(entity, ":")
+}
+
+var tailnetPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("fd7a:115c:a1e0::/48"),
+}
+
+func isTailnetAddr(addr netip.Addr) bool {
+	for _, pfx := range tailnetPrefixes {
+		if pfx.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// addr resolves entity, a host name or IP address, to an address.
+func (p *policy) addr(entity string) (netip.Addr, bool) {
+	if host, ok := p.Hosts[entity]; ok {
+		entity = host
+	}
+	if pfx, err := netip.ParsePrefix(entity); err == nil {
+		return pfx.Addr(), true
+	}
+	addr, err := netip.ParseAddr(entity)
+	return addr, err == nil
+}
+
+// maxIPSetDepth limits how deeply ipsets may reference other ipsets.
+const maxIPSetDepth = 8
+
+// addrMatcher returns a function reporting whether an address is covered by
+// selector, one of a host name, IP address, CIDR, range or ipset.
+func (p *policy) addrMatcher(selector string, depth int) func(netip.Addr) bool {
+	none := func(netip.Addr) bool { return false }
+
+	if host, ok := p.Hosts[selector]; ok {
+		selector = host
+	}
+
+	if name, ok := strings.CutPrefix(selector, "ipset:"); ok {
+		entries, ok := p.IPSets["ipset:"+name]
+		if !ok || depth >= maxIPSetDepth {
+			return none
+		}
+
+		var add, remove []func(netip.Addr) bool
+		for _, entry := range entries {
+			if rest, ok := strings.CutPrefix(entry, "remove "); ok {
+				remove = append(remove, p.addrMatcher(strings.TrimSpace(rest), depth+1))
+				continue
+			}
+			entry = strings.TrimSpace(strings.TrimPrefix(entry, "add "))
+			add = append(add, p.addrMatcher(entry, depth+1))
+		}
+		return func(a netip.Addr) bool {
+			for _, fn := range remove {
+				if fn(a) {
+					return false
+				}
+			}
+			for _, fn := range add {
+				if fn(a) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	if name, ok := strings.CutPrefix(selector, "host:"); ok {
+		if _, ok := p.Hosts[name]; !ok {
+			return none
+		}
+		return p.addrMatcher(name, depth+1)
+	}
+
+	if pfx, err := netip.ParsePrefix(selector); err == nil {
+		return pfx.Contains
+	}
+	if addr, err := netip.ParseAddr(selector); err == nil {
+		return func(a netip.Addr) bool { return a == addr }
+	}
+	if from, to, ok := strings.Cut(selector, "-"); ok {
+		lo, errLo := netip.ParseAddr(from)
+		hi, errHi := netip.ParseAddr(to)
+		if errLo == nil && errHi == nil {
+			return func(a netip.Addr) bool { return lo.Compare(a) <= 0 && a.Compare(hi) <= 0 }
+		}
+	}
+	return none
+}
+
+// portsMatch reports whether port is in ports, e.g. "*", "22", "80,443" or
+// "1000-2000".
+func portsMatch(ports string, port int) bool {
+	for _, r := range strings.Split(ports, ",") {
+		r = strings.TrimSpace(r)
+		if r == "*" {
+			return true
+		}
+		from, to, isRange := strings.Cut(r, "-")
+		lo, err := strconv.Atoi(from)
+		if err != nil {
+			continue
+		}
+		hi := lo
+		if isRange {
+			hi, err = strconv.Atoi(to)
+			if err != nil {
+				continue
+			}
+		}
+		if lo <= port && port <= hi {
+			return true
+		}
+	}
+	return false
+}
+
+var protoNumbers = map[string]string{
+	"1":   "icmp",
+	"6":   "tcp",
+	"17":  "udp",
+	"58":  "ipv6-icmp",
+	"132": "sctp",
+}
+
+func normalizeProto(proto string) string {
+	proto = strings.ToLower(strings.TrimSpace(proto))
+	if name, ok := protoNumbers[proto]; ok {
+		return name
+	}
+	return proto
+}
+
+// protoMatches reports whether a rule for ruleProto applies to traffic using
+// proto. An empty ruleProto covers tcp, udp and icmp, and an empty proto is
+// treated as tcp.
+func protoMatches(ruleProto string, proto string) bool {
+	ruleProto, proto = normalizeProto(ruleProto), normalizeProto(proto)
+	if proto == "" {
+		proto = "tcp"
+	}
+	switch ruleProto {
+	case "", "*":
+		return ruleProto == "*" || proto == "tcp" || proto == "udp" || proto == "icmp"
+	default:
+		return ruleProto == proto
+	}
+}
+
+// postureAllows reports whether a device with attrs satisfies at least one
+// of the named postures, falling back to defaultSrcPosture when names is
+// empty.
+func (p *policy) postureAllows(names []string, attrs map[string]any) bool {
+	if len(names) == 0 {
+		names = p.DefaultSrcPosture
+	}
+	if len(names) == 0 {
+		return true
+	}
+
+	for _, name := range names {
+		conditions, ok := p.Postures[name]
+		if !ok {
+			continue
+		}
+		allTrue := true
+		for _, c := range conditions {
+			if !postureConditionHolds(c, attrs) {
+				allTrue = false
+				break
+			}
+		}
+		if allTrue {
+			return true
+		}
+	}
+	return false
+}
+
+var postureConditionRe = regexp.MustCompile(`^\s*(\S+)\s+(==|!=|<=|>=|<|>|IN|NOT IN|IS SET|NOT SET)\s*(.*?)\s*$`)
+
+// postureConditionHolds evaluates a single posture condition such as
+// "node:os IN ['macos', 'linux']" against attrs.
+//
+// https://tailscale.com/kb/1288/device-posture#posture-conditions
+func postureConditionHolds(condition string, attrs map[string]any) bool {
+	m := postureConditionRe.FindStringSubmatch(condition)
+	if m == nil {
+		return false
+	}
+	attr, op, operand := m[1], m[2], m[3]
+
+	raw, isSet := attrs[attr]
+	switch op {
+	case "IS SET":
+		return isSet
+	case "NOT SET":
+		return !isSet
+	}
+	if !isSet {
+		return false
+	}
+	value := fmt.Sprint(raw)
+
+	switch op {
+	case "IN", "NOT IN":
+		found := false
+		for _, v := range strings.Split(strings.Trim(operand, "[]"), ",") {
+			if unquote(v) == value {
+				found = true
+				break
+			}
+		}
+		return found == (op == "IN")
+	case "==":
+		return value == unquote(operand)
+	case "!=":
+		return value != unquote(operand)
+	}
+
+	cmp := compareVersions(value, unquote(operand))
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func unquote(s string) string {
+	return strings.Trim(strings.TrimSpace(s), `'"`)
+}
+
+// compareVersions compares dotted versions like "1.40.2" numerically,
+// falling back to a string comparison for parts that are not numbers.
+func compareVersions(a string, b string) int {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aPart, bPart string
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+
+		aNum, aErr := strconv.Atoi(aPart)
+		bNum, bErr := strconv.Atoi(bPart)
+		if aErr == nil && bErr == nil || aPart == "" || bPart == "" {
+			if aNum != bNum {
+				if aNum < bNum {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		if c := strings.Compare(aPart, bPart); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
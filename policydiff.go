@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/jtree/ast"
+	"github.com/creachadair/jtree/jwcc"
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+// nestedSections are objects whose members are diffed as sections of their
+// own, e.g. autoApprovers.routes.
+var nestedSections = map[string]bool{
+	"autoApprovers": true,
+	"derpMap":       true,
+}
+
+// orderedSections are arrays whose entries are evaluated in order, so moving
+// an entry changes the policy, e.g. the first matching ssh rule applies.
+var orderedSections = []string{"ssh"}
+
+// policyChange is an entry added to, removed from or modified in a section
+// of a policy.
+type policyChange struct {
+	Section string
+	// Kind is '+' for an added entry, '-' for a removed entry and '~' for a
+	// modified entry.
+	Kind byte
+	// Key is the key of an object member, or "" for array entries and
+	// top-level options.
+	Key string
+	Old string
+	New string
+	// Added and Removed are the values added to and removed from a modified
+	// member whose value is an array, such as the members of a group.
+	Added   []string
+	Removed []string
+	// Source is the file the entry came from according to its provenance
+	// comment, if any.
+	Source string
+}
+
+// diffPolicies returns the entries added, removed and modified from oldDoc
+// to newDoc. Array entries, such as acls, are matched by their content and
+// object members, such as groups, by their key, so moving an entry does not
+// count as a change.
+func diffPolicies(oldDoc *jwcc.Object, newDoc *jwcc.Object) []policyChange {
+	oldSections, newSections := policySections(oldDoc), policySections(newDoc)
+	keys := slices.Concat(slices.Collect(maps.Keys(oldSections)), slices.Collect(maps.Keys(newSections)))
+	slices.Sort(keys)
+
+	changes := []policyChange{}
+	for _, key := range slices.Compact(keys) {
+		changes = diffValues(changes, key, oldSections[key], newSections[key])
+	}
+	return changes
+}
+
+// policySections returns the sections of doc keyed by their canonical
+// names, since policy files accept any casing of section names.
+func policySections(doc *jwcc.Object) map[string]jwcc.Value {
+	registry := combiner.NewRegistry()
+	sections := map[string]jwcc.Value{}
+	for _, m := range doc.Members {
+		sections[registry.CanonicalName(m.Key.String())] = m.Value
+	}
+	return sections
+}
+
+// diffOrder returns a change for each of orderedSections that holds the
+// same entries in oldDoc and newDoc, but in a different order, with the
+// first entries that differ. Other differences are left to diffPolicies.
+func diffOrder(oldDoc *jwcc.Object, newDoc *jwcc.Object) []policyChange {
+	changes := []policyChange{}
+	for _, section := range orderedSections {
+		oldArr, oldIsArr := policySections(oldDoc)[section].(*jwcc.Array)
+		newArr, newIsArr := policySections(newDoc)[section].(*jwcc.Array)
+		if !oldIsArr || !newIsArr || len(diffArrays(nil, section, oldArr, newArr)) > 0 {
+			continue
+		}
+
+		source := ""
+		for i, v := range newArr.Values {
+			if path, ok := combiner.Provenance(v); ok {
+				source = path
+			}
+			oldJSON, newJSON := canonicalJSON(oldArr.Values[i]), canonicalJSON(v)
+			if oldJSON != newJSON {
+				changes = append(changes, policyChange{Section: section, Kind: '~', Old: oldJSON, New: newJSON, Source: source})
+				break
+			}
+		}
+	}
+	return changes
+}
+
+// diffPoliciesInOrder returns the changes from diffPolicies along with those
+// from diffOrder, grouped by section.
+func diffPoliciesInOrder(oldDoc *jwcc.Object, newDoc *jwcc.Object) []policyChange {
+	changes := append(diffPolicies(oldDoc, newDoc), diffOrder(oldDoc, newDoc)...)
+	slices.SortStableFunc(changes, func(a, b policyChange) int {
+		return strings.Compare(a.Section, b.Section)
+	})
+	return changes
+}
+
+func diffValues(changes []policyChange, section string, oldV jwcc.Value, newV jwcc.Value) []policyChange {
+	oldArr, oldIsArr := oldV.(*jwcc.Array)
+	newArr, newIsArr := newV.(*jwcc.Array)
+	oldObj, oldIsObj := oldV.(*jwcc.Object)
+	newObj, newIsObj := newV.(*jwcc.Object)
+
+	switch {
+	case (oldIsArr || oldV == nil) && (newIsArr || newV == nil):
+		return diffArrays(changes, section, oldArr, newArr)
+	case nestedSections[section] && (oldIsObj || oldV == nil) && (newIsObj || newV == nil):
+		for _, key := range memberKeys(oldObj, newObj) {
+			changes = diffValues(changes, section+"."+key, memberValue(oldObj, key), memberValue(newObj, key))
+		}
+		return changes
+	case (oldIsObj || oldV == nil) && (newIsObj || newV == nil):
+		return diffObjects(changes, section, oldObj, newObj)
+	}
+
+	change := policyChange{Section: section}
+	switch {
+	case oldV == nil:
+		change.Kind, change.New = '+', canonicalJSON(newV)
+	case newV == nil:
+		change.Kind, change.Old = '-', canonicalJSON(oldV)
+	case canonicalJSON(oldV) != canonicalJSON(newV):
+		change.Kind, change.Old, change.New = '~', canonicalJSON(oldV), canonicalJSON(newV)
+	default:
+		return changes
+	}
+	return append(changes, change)
+}
+
+// diffArrays reports the entries of newArr not in oldArr as added and the
+// entries of oldArr not in newArr as removed. Either may be nil.
+func diffArrays(changes []policyChange, section string, oldArr *jwcc.Array, newArr *jwcc.Array) []policyChange {
+	removed := unmatchedValues(oldArr, newArr)
+	added := unmatchedValues(newArr, oldArr)
+
+	for _, v := range removed {
+		changes = append(changes, policyChange{Section: section, Kind: '-', Old: v.json, Source: v.source})
+	}
+	for _, v := range added {
+		changes = append(changes, policyChange{Section: section, Kind: '+', New: v.json, Source: v.source})
+	}
+	return changes
+}
+
+type sourcedValue struct {
+	json   string
+	source string
+}
+
+// unmatchedValues returns the values in arr that are not in other, counting
+// repeated values separately, along with the file each came from.
+func unmatchedValues(arr *jwcc.Array, other *jwcc.Array) []sourcedValue {
+	if arr == nil {
+		return nil
+	}
+
+	counts := map[string]int{}
+	if other != nil {
+		for _, v := range other.Values {
+			counts[canonicalJSON(v)]++
+		}
+	}
+
+	unmatched := []sourcedValue{}
+	source := ""
+	for _, v := range arr.Values {
+		if path, ok := combiner.Provenance(v); ok {
+			source = path
+		}
+		key := canonicalJSON(v)
+		if counts[key] > 0 {
+			counts[key]--
+			continue
+		}
+		unmatched = append(unmatched, sourcedValue{json: key, source: source})
+	}
+	return unmatched
+}
+
+// diffObjects reports the members added to, removed from and modified
+// between oldObj and newObj, matched by key. Either may be nil.
+func diffObjects(changes []policyChange, section string, oldObj *jwcc.Object, newObj *jwcc.Object) []policyChange {
+	oldSources := memberSources(oldObj)
+	newSources := memberSources(newObj)
+
+	for _, key := range memberKeys(oldObj, newObj) {
+		oldV := memberValue(oldObj, key)
+		newV := memberValue(newObj, key)
+
+		change := policyChange{Section: section, Key: key}
+		switch {
+		case oldV == nil:
+			change.Kind, change.New, change.Source = '+', canonicalJSON(newV), newSources[key]
+		case newV == nil:
+			change.Kind, change.Old, change.Source = '-', canonicalJSON(oldV), oldSources[key]
+		case canonicalJSON(oldV) == canonicalJSON(newV):
+			continue
+		default:
+			change.Kind, change.Source = '~', newSources[key]
+			oldArr, oldIsArr := oldV.(*jwcc.Array)
+			newArr, newIsArr := newV.(*jwcc.Array)
+			if oldIsArr && newIsArr {
+				for _, v := range unmatchedValues(newArr, oldArr) {
+					change.Added = append(change.Added, v.json)
+				}
+				for _, v := range unmatchedValues(oldArr, newArr) {
+					change.Removed = append(change.Removed, v.json)
+				}
+			}
+			if len(change.Added) == 0 && len(change.Removed) == 0 {
+				change.Old, change.New = canonicalJSON(oldV), canonicalJSON(newV)
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// memberSources returns the file each member of obj came from according to
+// provenance comments, which are only on the first member from each file.
+func memberSources(obj *jwcc.Object) map[string]string {
+	sources := map[string]string{}
+	if obj == nil {
+		return sources
+	}
+
+	source := ""
+	for _, m := range obj.Members {
+		if path, ok := combiner.Provenance(m); ok {
+			source = path
+		}
+		sources[m.Key.String()] = source
+	}
+	return sources
+}
+
+// memberKeys returns the sorted keys of the members of a and b, either of
+// which may be nil.
+func memberKeys(a *jwcc.Object, b *jwcc.Object) []string {
+	keys := []string{}
+	for _, obj := range []*jwcc.Object{a, b} {
+		if obj == nil {
+			continue
+		}
+		for _, m := range obj.Members {
+			if !slices.Contains(keys, m.Key.String()) {
+				keys = append(keys, m.Key.String())
+			}
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// memberValue returns the value of key in obj, or nil if obj is nil or has
+// no such member.
+func memberValue(obj *jwcc.Object, key string) jwcc.Value {
+	if obj == nil {
+		return nil
+	}
+	m := obj.FindKey(ast.TextEqual(key))
+	if m == nil {
+		return nil
+	}
+	return m.Value
+}
+
+// canonicalJSON returns v as compact JSON with object keys sorted, so values
+// that differ only in comments, formatting or key order are equal.
+func canonicalJSON(v jwcc.Value) string {
+	raw := v.Undecorate().JSON()
+
+	var decoded any
+	err := json.Unmarshal([]byte(raw), &decoded)
+	if err != nil {
+		return raw
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(decoded)
+	if err != nil {
+		return raw
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// reportChanges writes changes to w grouped by section and returns the
+// number of changes.
+func reportChanges(w io.Writer, changes []policyChange) int {
+	added, removed, modified := 0, 0, 0
+	section := ""
+	for _, c := range changes {
+		if c.Section != section {
+			section = c.Section
+			fmt.Fprintf(w, "%s:\n", section)
+		}
+
+		fmt.Fprintf(w, "  %c ", c.Kind)
+		if c.Key != "" {
+			fmt.Fprintf(w, "%q: ", c.Key)
+		}
+		switch c.Kind {
+		case '+':
+			added++
+			fmt.Fprint(w, c.New)
+		case '-':
+			removed++
+			fmt.Fprint(w, c.Old)
+		case '~':
+			modified++
+			if c.Old != "" || c.New != "" {
+				fmt.Fprintf(w, "%s -> %s", c.Old, c.New)
+			}
+			values := []string{}
+			for _, v := range c.Added {
+				values = append(values, "+"+v)
+			}
+			for _, v := range c.Removed {
+				values = append(values, "-"+v)
+			}
+			fmt.Fprint(w, strings.Join(values, ", "))
+		}
+		if c.Source != "" {
+			fmt.Fprintf(w, " (from `%s`)", c.Source)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%d added, %d removed, %d modified\n", added, removed, modified)
+	return len(changes)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/jtree/jwcc"
+)
+
+func parseTestObject(t *testing.T, src string) *jwcc.Object {
+	t.Helper()
+	doc, err := jwcc.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	return doc.Value.(*jwcc.Object)
+}
+
+func TestDiffPolicies(t *testing.T) {
+	oldDoc := parseTestObject(t, `{
+		"randomizeClientPort": false,
+		"acls": [
+			{"action": "accept", "src": ["group:eng"], "dst": ["tag:dev:*"]},
+			{"action": "accept", "src": ["group:sales"], "dst": ["tag:crm:443"]},
+		],
+		"groups": {
+			"group:eng":   ["alice@example.com", "bob@example.com"],
+			"group:sales": ["carol@example.com"],
+		},
+		"autoApprovers": {
+			"routes": {"10.0.0.0/8": ["tag:router"]},
+		},
+	}`)
+	newDoc := parseTestObject(t, `{
+		"acls": [
+			{"src": ["group:sales"], "dst": ["tag:crm:443"], "action": "accept"}, // moved and reordered
+			// from `+"`child.hujson`"+`
+			{"action": "accept", "src": ["group:eng"], "dst": ["tag:prod:*"]},
+		],
+		"groups": {
+			"group:eng":   ["alice@example.com", "dave@example.com"],
+			"group:sales": ["carol@example.com"],
+		},
+		"tagOwners": {
+			"tag:prod": ["group:eng"],
+		},
+		"autoApprovers": {
+			"routes": {"10.0.0.0/8": ["tag:router"], "192.168.0.0/24": ["tag:router"]},
+		},
+		"randomizeClientPort": true,
+	}`)
+
+	var buf bytes.Buffer
+	count := reportChanges(&buf, diffPolicies(oldDoc, newDoc))
+
+	expected := `acls:
+  - {"action":"accept","dst":["tag:dev:*"],"src":["group:eng"]}
+  + {"action":"accept","dst":["tag:prod:*"],"src":["group:eng"]} (from ` + "`child.hujson`" + `)
+autoApprovers.routes:
+  + "192.168.0.0/24": ["tag:router"]
+groups:
+  ~ "group:eng": +"dave@example.com", -"bob@example.com"
+randomizeClientPort:
+  ~ false -> true
+tagOwners:
+  + "tag:prod": ["group:eng"]
+3 added, 1 removed, 2 modified
+`
+	if buf.String() != expected {
+		t.Fatalf("output should be [%v], got [%v]", expected, buf.String())
+	}
+	if count != 6 {
+		t.Fatalf("count should be [6], got [%v]", count)
+	}
+}
+
+func TestDiffPoliciesUnchanged(t *testing.T) {
+	oldDoc := parseTestObject(t, `{"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}], "groups": {"group:a": []}}`)
+	newDoc := parseTestObject(t, `{
+		// comments and formatting are ignored
+		"groups": {"group:a": []},
+		"acls": [
+			{"action": "accept", "src": ["*"], "dst": ["*:*"]},
+		],
+	}`)
+
+	changes := diffPolicies(oldDoc, newDoc)
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes, got [%v]", changes)
+	}
+}
+
+func TestDiffPoliciesSectionCase(t *testing.T) {
+	oldDoc := parseTestObject(t, `{"ACLs": [{"src": ["a"]}], "RandomizeClientPort": true}`)
+	newDoc := parseTestObject(t, `{"acls": [{"src": ["a"]}, {"src": ["b"]}], "randomizeClientPort": true}`)
+
+	changes := diffPolicies(oldDoc, newDoc)
+	if len(changes) != 1 || changes[0].Section != "acls" || changes[0].Kind != '+' || changes[0].New != `{"src":["b"]}` {
+		t.Fatalf("expected one added acl, got [%v]", changes)
+	}
+}
+
+func TestDiffPoliciesRepeatedEntries(t *testing.T) {
+	oldDoc := parseTestObject(t, `{"tests": [{"src": "a"}]}`)
+	newDoc := parseTestObject(t, `{"tests": [{"src": "a"}, {"src": "a"}]}`)
+
+	changes := diffPolicies(oldDoc, newDoc)
+	if len(changes) != 1 || changes[0].Kind != '+' || changes[0].New != `{"src":"a"}` {
+		t.Fatalf("expected one added test, got [%v]", changes)
+	}
+}
+
+func TestDiffOrder(t *testing.T) {
+	oldDoc := parseTestObject(t, `{
+		"acls": [{"src": ["a"]}, {"src": ["b"]}],
+		"ssh":  [{"action": "check"}, {"action": "accept"}],
+	}`)
+	newDoc := parseTestObject(t, `{
+		"acls": [{"src": ["b"]}, {"src": ["a"]}],
+		"ssh": [
+			// from `+"`b.hujson`"+`
+			{"action": "accept"},
+			{"action": "check"},
+		],
+	}`)
+
+	if changes := diffPolicies(oldDoc, newDoc); len(changes) != 0 {
+		t.Fatalf("expected no changes ignoring order, got [%v]", changes)
+	}
+	changes := diffOrder(oldDoc, newDoc)
+	expected := policyChange{Section: "ssh", Kind: '~', Old: `{"action":"check"}`, New: `{"action":"accept"}`, Source: "b.hujson"}
+	if len(changes) != 1 || !reflect.DeepEqual(changes[0], expected) {
+		t.Fatalf("expected [%v], got [%v]", expected, changes)
+	}
+
+	if changes := diffOrder(oldDoc, oldDoc); len(changes) != 0 {
+		t.Fatalf("expected no changes, got [%v]", changes)
+	}
+}
+
+func TestDiffPoliciesInOrder(t *testing.T) {
+	oldDoc := parseTestObject(t, `{
+		"acls":   [{"src": ["a"]}, {"src": ["b"]}],
+		"groups": {"group:a": []},
+		"ssh":    [{"action": "check"}, {"action": "accept"}],
+	}`)
+	newDoc := parseTestObject(t, `{
+		"acls":      [{"src": ["b"]}, {"src": ["a"]}],
+		"ssh":       [{"action": "accept"}, {"action": "check"}],
+		"tagOwners": {"tag:a": []},
+	}`)
+
+	var buf bytes.Buffer
+	reportChanges(&buf, diffPoliciesInOrder(oldDoc, newDoc))
+	// Moving acls is not a change, but moving an ssh rule is.
+	expected := `groups:
+  - "group:a": []
+ssh:
+  ~ {"action":"check"} -> {"action":"accept"}
+tagOwners:
+  + "tag:a": []
+1 added, 1 removed, 1 modified
+`
+	if buf.String() != expected {
+		t.Fatalf("expected [%s], got [%s]", expected, buf.String())
+	}
+}
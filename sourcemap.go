@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+// writeSourceMap writes the source map of the combined policy in result to
+// path as JSON.
+func writeSourceMap(result *combiner.Result, path string) error {
+	m, err := result.SourceMap()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// readSourceMap reads a source map written by writeSourceMap.
+func readSourceMap(path string) (*combiner.SourceMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &combiner.SourceMap{}
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("error reading source map [%s]: %w", path, err)
+	}
+	return m, nil
+}
+
+// runLocate prints the file and position the line given as the argument,
+// a line of the combined policy, came from according to the source map from
+// -sourcemap, and returns the exit code.
+func runLocate() int {
+	if *sourceMapFile == "" {
+		fmt.Fprintf(os.Stderr, "missing argument -sourcemap - a source map written when combining must be provided\n")
+		usage()
+		return 1
+	}
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "expected 1 line number, got %d\n", flag.NArg())
+		usage()
+		return 1
+	}
+	line, err := strconv.Atoi(flag.Arg(0))
+	if err != nil || line < 1 {
+		fmt.Fprintf(os.Stderr, "invalid line number [%s]\n", flag.Arg(0))
+		usage()
+		return 1
+	}
+
+	m, err := readSourceMap(*sourceMapFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	return locate(os.Stdout, m, line)
+}
+
+// locate writes the source of line to w and returns the exit code.
+func locate(w io.Writer, m *combiner.SourceMap, line int) int {
+	entry, ok := m.Locate(line)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "line %d is not part of an entry read from a policy file\n", line)
+		return 1
+	}
+	fmt.Fprintf(w, "%s:%d:%d: %s\n", entry.File, entry.Line, entry.Column, entry.Pointer)
+	return 0
+}
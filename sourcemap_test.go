@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+func TestLocate(t *testing.T) {
+	m := &combiner.SourceMap{Entries: []combiner.SourceMapEntry{
+		{Pointer: "/acls/0", OutputStart: 3, OutputEnd: 7, Source: combiner.Source{File: "a.hujson", Line: 10, Column: 3}},
+		{Pointer: "/acls/0/src", OutputStart: 5, OutputEnd: 5, Source: combiner.Source{File: "a.hujson", Line: 12, Column: 4}},
+		{Pointer: "/acls/0/src/0", OutputStart: 5, OutputEnd: 5, Source: combiner.Source{File: "a.hujson", Line: 12, Column: 12}},
+	}}
+
+	tests := []struct {
+		line     int
+		expected string
+		code     int
+	}{
+		{line: 4, expected: "a.hujson:10:3: /acls/0\n"},
+		{line: 5, expected: "a.hujson:12:4: /acls/0/src\n"},
+		{line: 8, code: 1},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		code := locate(&out, m, tt.line)
+		if code != tt.code || out.String() != tt.expected {
+			t.Fatalf("line [%d] should be located at [%q] with code [%d], got [%q] with code [%d]", tt.line, tt.expected, tt.code, out.String(), code)
+		}
+	}
+}
+
+func TestWriteSourceMap(t *testing.T) {
+	result, err := combiner.New(
+		combiner.WithParent("testdata/input-parent.hujson"),
+		combiner.WithChildDir("testdata/departments"),
+		combiner.WithAllow("acls", "autoApprovers", "grants", "groups", "ipsets", "ssh", "tests", "sshTests"),
+	).Combine(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "out.map.json")
+	err = writeSourceMap(result, path)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	m, err := readSourceMap(path)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	// the first acl in the output is engineering1, starting on line 5 after
+	// the generated comment.
+	var out bytes.Buffer
+	locate(&out, m, 5)
+	expected := "testdata/departments/engineering/acls.hujson:10:3: /acls/0\n"
+	if out.String() != expected {
+		t.Fatalf("line [5] should be located at [%q], got [%q]", expected, out.String())
+	}
+}
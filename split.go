@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/jtree/jwcc"
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+	"github.com/tailscale/hujson"
+)
+
+// runSplit splits the policy from -f into the parent file -o and child files
+// under -d using the rules from -mapping, then combines them to check that
+// they reproduce the policy, and returns the exit code.
+func runSplit() int {
+	formatErr := checkDiagnosticsFormat()
+	if formatErr != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", formatErr)
+		usage()
+		return 1
+	}
+	for _, arg := range []struct{ value, name, message string }{
+		{*inParentFile, "f", "a policy file to split must be provided"},
+		{*outFile, "o", "a parent file to write must be provided"},
+		{*splitMapping, "mapping", "a file of rules for moving entries to child files must be provided"},
+	} {
+		if arg.value == "" {
+			fmt.Fprintf(os.Stderr, "missing argument -%s - %s\n", arg.name, arg.message)
+			usage()
+			return 1
+		}
+	}
+	if *outFile == "-" {
+		fmt.Fprintf(os.Stderr, "invalid argument -o - split writes the parent file to a path, not stdout\n")
+		usage()
+		return 1
+	}
+	if len(inChildDirs) != 1 {
+		fmt.Fprintf(os.Stderr, "missing argument -d - exactly one directory to write child files to must be provided\n")
+		usage()
+		return 1
+	}
+	childDir := inChildDirs[0]
+
+	rules, err := combiner.LoadSplitRules(*splitMapping)
+	if err != nil {
+		reportDiagnostics(nil, err)
+		return 1
+	}
+	doc, err := combiner.Parse(*inParentFile)
+	if err != nil {
+		reportDiagnostics(nil, err)
+		return 1
+	}
+	// Split moves entries out of doc, and -o may replace -f, so the
+	// original is kept to check the split against.
+	original, err := combiner.Parse(*inParentFile)
+	if err != nil {
+		reportDiagnostics(nil, err)
+		return 1
+	}
+	split, err := combiner.Split(doc, rules)
+	if err != nil {
+		reportDiagnostics(nil, err)
+		return 1
+	}
+
+	err = writeSplit(split, *outFile, childDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	logVerbose("wrote [%s] and %d child files to [%s]\n", *outFile, len(split.Children), childDir)
+
+	result, err := combiner.New(
+		combiner.WithParent(*outFile),
+		combiner.WithChildDir(childDir),
+	).Combine(context.Background())
+	reportDiagnostics(result, err)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "combining the split files failed\n")
+		return 1
+	}
+
+	changes := diffPoliciesInOrder(original.Object, result.Policy)
+	if len(changes) > 0 {
+		fmt.Fprintf(os.Stderr, "combining the split files does not reproduce [%s]:\n", *inParentFile)
+		reportChanges(os.Stderr, changes)
+		return 1
+	}
+	logVerbose("combining the split files reproduces [%s]\n", *inParentFile)
+	return 0
+}
+
+// writeSplit writes the parent of split to parentPath and its children,
+// along with a config file allowing their sections, under childDir, which
+// must be empty or not exist yet.
+func writeSplit(split *combiner.SplitResult, parentPath string, childDir string) error {
+	files := map[string]*jwcc.Object{}
+	for name, child := range split.Children {
+		files[filepath.Join(childDir, name)] = child
+	}
+	configPath := filepath.Join(childDir, combiner.DefaultConfigFile)
+	config, err := jwcc.Parse(strings.NewReader(fmt.Sprintf(`{"allow": {"**": [%s]}}`, quoteAll(split.Sections))))
+	if err != nil {
+		return err
+	}
+	files[configPath] = config.Value.(*jwcc.Object)
+
+	// The split is checked by combining every file under childDir, so
+	// files already there would be merged along with the split ones.
+	entries, err := os.ReadDir(childDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("[%s] is not empty, split into an empty directory", childDir)
+	}
+
+	files[parentPath] = split.Parent
+	for _, path := range slices.Sorted(maps.Keys(files)) {
+		formatted, err := combiner.Format(files[path])
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, ".json") {
+			formatted, err = standardJSON(formatted)
+			if err != nil {
+				return err
+			}
+		}
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		err = writeFileAtomic(path, formatted)
+		if err != nil {
+			return err
+		}
+		logVerbose("wrote [%s]\n", path)
+	}
+	return nil
+}
+
+// standardJSON converts the HuJSON in data to indented standard JSON, for
+// files with a .json extension.
+func standardJSON(data []byte) ([]byte, error) {
+	standardized, err := hujson.Standardize(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = json.Indent(&buf, standardized, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/tailscale-dev/tailscale-acl-combiner/combiner"
+)
+
+func TestWriteSplit(t *testing.T) {
+	doc, err := combiner.Parse("testdata/output-file-to-compare-to.hujson")
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	rules := []combiner.SplitRule{
+		{From: true, Pattern: regexp.MustCompile("^testdata/departments/(.*)$"), File: "$1"},
+	}
+	split, err := combiner.Split(doc, rules)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	dir := t.TempDir()
+	parentPath := filepath.Join(dir, "parent.hujson")
+	childDir := filepath.Join(dir, "children")
+	err = writeSplit(split, parentPath, childDir)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(childDir, "engineering", "acls.json"))
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if !json.Valid(data) {
+		t.Fatalf(".json child should be standard JSON, got [%s]", data)
+	}
+
+	result, err := combiner.New(
+		combiner.WithParent(parentPath),
+		combiner.WithChildDir(childDir),
+	).Combine(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	original, err := combiner.Parse("testdata/output-file-to-compare-to.hujson")
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if changes := diffPolicies(original.Object, result.Policy); len(changes) != 0 {
+		t.Fatalf("combining the split files should reproduce the policy, got changes [%v]", changes)
+	}
+
+	err = writeSplit(split, parentPath, childDir)
+	if err == nil || !strings.HasSuffix(err.Error(), "is not empty, split into an empty directory") {
+		t.Fatalf("expected error for existing files, got [%v]", err)
+	}
+
+	// Unrelated files would be merged when checking the split.
+	otherDir := filepath.Join(dir, "other")
+	err = os.MkdirAll(otherDir, 0755)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	err = os.WriteFile(filepath.Join(otherDir, "notes.txt"), nil, 0644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	err = writeSplit(split, parentPath, otherDir)
+	if err == nil || err.Error() != "["+otherDir+"] is not empty, split into an empty directory" {
+		t.Fatalf("expected error for a non-empty directory, got [%v]", err)
+	}
+}
+
+func TestRunSplitInPlace(t *testing.T) {
+	dir := t.TempDir()
+	policyPath := filepath.Join(dir, "policy.hujson")
+	mappingPath := filepath.Join(dir, "split.hujson")
+	policy := `{
+	"acls": [
+		{"action": "accept", "src": ["group:eng"], "dst": ["tag:eng:*"]},
+		{"action": "accept", "src": ["*"], "dst": ["tag:web:443"]},
+	],
+}
+`
+	err := os.WriteFile(policyPath, []byte(policy), 0644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	err = os.WriteFile(mappingPath, []byte(`{"match": {"^group:eng": "eng.hujson"}}`), 0644)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+
+	parent, out, mapping, dirs := *inParentFile, *outFile, *splitMapping, inChildDirs
+	t.Cleanup(func() { *inParentFile, *outFile, *splitMapping, inChildDirs = parent, out, mapping, dirs })
+	// The parent replaces the policy it was split from.
+	*inParentFile, *outFile, *splitMapping = policyPath, policyPath, mappingPath
+	inChildDirs = repeatedFlag{filepath.Join(dir, "children")}
+
+	if code := runSplit(); code != 0 {
+		t.Fatalf("expected exit code [0], got [%d]", code)
+	}
+	data, err := os.ReadFile(policyPath)
+	if err != nil {
+		t.Fatalf("expected no error, got [%v]", err)
+	}
+	if strings.Contains(string(data), "group:eng") || !strings.Contains(string(data), "tag:web:443") {
+		t.Fatalf("policy should be replaced by the parent file, got [%s]", data)
+	}
+}